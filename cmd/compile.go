@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"polinco/com"
+	"polinco/po"
+	"strings"
+)
+
+/***
+ * polinco compile: msgfmt の代わりに *.po から *.mo を作る.
+ * plugin/resources/locales/<locale>/<domain>.po の隣に <domain>.mo を書き出す
+ */
+func compileMain(args []string) int {
+	fs := flag.NewFlagSet("compile", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	force := fs.Bool("force", false, "write *.mo files even if *.po files have errors")
+	fs.Parse(args)
+
+	linter := opts.newLinter()
+	for _, plugin := range opts.plugins {
+		files, err := filepath.Glob(plugin + "/resources/locales/*/*.po")
+		if err != nil {
+			linter.Logger.Fatal(err)
+			return 1
+		}
+
+		for _, file := range files {
+			compilePoFile(linter, file, *force)
+		}
+	}
+
+	if linter.Reporter.CountError() > 0 {
+		fmt.Printf("exit ... compile err %d\n", linter.Reporter.CountError())
+		return 1
+	}
+	return 0
+}
+
+func compilePoFile(linter *com.Linter, filename string, force bool) {
+	nerr := linter.Reporter.CountError()
+	pofile := loadPoFile(linter, filename)
+	if pofile == nil {
+		return
+	}
+	if _, _, err := checkPoFile(linter, filename, pofile); err != nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+
+	if linter.Reporter.CountError() > nerr && !force {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, "skip compiling because of errors")
+		return
+	}
+
+	mofile := strings.TrimSuffix(filename, ".po") + ".mo"
+	linter.Dprintf("compile %s -> %s\n", filename, mofile)
+
+	var buf bytes.Buffer
+	if err := po.WriteMo(&buf, pofile); err != nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+	if err := writeFileAtomic(mofile, buf.Bytes()); err != nil {
+		linter.Reporter.ReportError(mofile, 0, 0, com.LevelError, err.Error())
+	}
+}
+
+// 途中で失敗しても壊れたファイルを残さないように,
+// 一時ファイルに書いてから置き換える
+func writeFileAtomic(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(filename); err == nil {
+		mode = st.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".polinco-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"polinco/com"
+	"polinco/php"
+	"polinco/po"
+	"sort"
+	"time"
+)
+
+/***
+ * polinco extract: xgettext の代わりに -src の *.php から
+ * ドメインごとの <domain>.pot を作る
+ */
+func extractMain(args []string) int {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	src_dir := fs.String("src", "", "source directory")
+	output := fs.String("output", "resources/locales", "directory to write *.pot files")
+	width := fs.Int("width", 79, "wrap strings at the specified width (0: no wrap)")
+	fs.Parse(args)
+
+	linter := opts.newLinter()
+	if *src_dir == "" {
+		linter.Reporter.ReportError("", 0, 0, com.LevelError, "-src is required")
+		return 1
+	}
+
+	config := opts.newPHPConfig(linter)
+	if config == nil {
+		return 1
+	}
+
+	// #: は -check-ref と同じく plugin ディレクトリからの相対パス.
+	// output が plugin/resources/locales なので 2 つ上
+	catalog := php.NewCatalog(filepath.Dir(filepath.Dir(*output)))
+	if err := php.ExtractPHPDir(linter, config, *src_dir, catalog); err != nil {
+		return 1
+	}
+
+	domains := make([]string, 0, len(catalog.Domains))
+	for domain := range catalog.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	if err := os.MkdirAll(*output, 0755); err != nil {
+		linter.Reporter.ReportError(*output, 0, 0, com.LevelError, err.Error())
+		return 1
+	}
+	for _, domain := range domains {
+		potfile := filepath.Join(*output, domain+".pot")
+		linter.Dprintf("extract %s: %d msgids\n", potfile, len(catalog.Domains[domain]))
+
+		var buf bytes.Buffer
+		f := &po.File{Header: newPotHeader(), Entries: catalog.Domains[domain]}
+		if err := po.Format(&buf, f, po.FormatOption{Width: *width}); err != nil {
+			linter.Reporter.ReportError(potfile, 0, 0, com.LevelError, err.Error())
+			continue
+		}
+		if err := writeFileAtomic(potfile, buf.Bytes()); err != nil {
+			linter.Reporter.ReportError(potfile, 0, 0, com.LevelError, err.Error())
+		}
+	}
+
+	if linter.Reporter.CountError() > 0 {
+		fmt.Printf("exit ... extract err %d\n", linter.Reporter.CountError())
+		return 1
+	}
+	return 0
+}
+
+// xgettext が書き出すのと同じテンプレートのヘッダ
+func newPotHeader() *po.Header {
+	h := po.NewHeader([]po.HeaderField{
+		{Key: "Project-Id-Version", Value: "PACKAGE VERSION"},
+		{Key: "Report-Msgid-Bugs-To", Value: ""},
+		{Key: "POT-Creation-Date", Value: time.Now().Format("2006-01-02 15:04-0700")},
+		{Key: "PO-Revision-Date", Value: "YEAR-MO-DA HO:MI+ZONE"},
+		{Key: "Last-Translator", Value: "FULL NAME <EMAIL@ADDRESS>"},
+		{Key: "Language-Team", Value: "LANGUAGE <LL@li.org>"},
+		{Key: "Language", Value: ""},
+		{Key: "MIME-Version", Value: "1.0"},
+		{Key: "Content-Type", Value: "text/plain; charset=UTF-8"},
+		{Key: "Content-Transfer-Encoding", Value: "8bit"},
+	})
+	h.Entry.Flags = []string{"fuzzy"}
+	return h
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/hiwane/flagvar"
+	"os"
+	"path/filepath"
+	"polinco/com"
+	"polinco/po"
+	"strings"
+)
+
+/***
+ * polinco fmt: *.po を決まった書式に書き直す.
+ * --check の場合は書き換えずに報告のみ
+ */
+func fmtMain(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	width := fs.Int("width", 79, "wrap strings at the specified width (0: no wrap)")
+	check := fs.Bool("check", false, "report unformatted files without rewriting them")
+	sorts := []string{"none", po.SortMsgID, po.SortReference}
+	opt_sort := flagvar.NewChoiceVar(sorts[0], sorts)
+	fs.Var(opt_sort, "sort", fmt.Sprintf("sort entries (choose from %v)", sorts))
+	fs.Parse(args)
+
+	linter := opts.newLinter()
+	option := po.FormatOption{Width: *width}
+	if opt_sort.String() != "none" {
+		option.Sort = opt_sort.String()
+	}
+
+	// -plugin のものと引数で指定したもの
+	files := make([]string, 0)
+	for _, plugin := range opts.plugins {
+		pofiles, err := filepath.Glob(plugin + "/resources/locales/*/*.po")
+		if err != nil {
+			linter.Logger.Fatal(err)
+			return 1
+		}
+		files = append(files, pofiles...)
+	}
+	files = append(files, fs.Args()...)
+
+	for _, file := range files {
+		formatPoFile(linter, file, option, *check)
+	}
+
+	if linter.Reporter.CountError() > 0 {
+		fmt.Printf("exit ... fmt err %d\n", linter.Reporter.CountError())
+		return 1
+	}
+	return 0
+}
+
+func formatPoFile(linter *com.Linter, filename string, option po.FormatOption, check bool) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+
+	pofile, err := poOption.parser.Parse(bytes.NewReader(src))
+	if pofile == nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+	if len(pofile.Diagnostics) > 0 {
+		// 読めなかった部分が消えたり, 不正なエスケープ \z が \\z になって
+		// msgid が変わったりするので, 警告だけでも書き直さない
+		for _, d := range pofile.Diagnostics {
+			linter.Reporter.ReportError(filename, d.Pos.Line, d.Pos.Column, d.Level, d.Msg)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := po.Format(&buf, pofile, option); err != nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+	if bytes.Equal(buf.Bytes(), src) {
+		return
+	}
+
+	if check {
+		linter.Reporter.ReportError(filename, firstDiffLine(src, buf.Bytes()), 0, com.LevelError, "file is not formatted; run polinco fmt")
+		return
+	}
+
+	linter.Dprintf("fmt %s\n", filename)
+	if err := writeFileAtomic(filename, buf.Bytes()); err != nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+	}
+}
+
+// 最初に異なる行番号
+func firstDiffLine(a, b []byte) int {
+	la := strings.Split(string(a), "\n")
+	lb := strings.Split(string(b), "\n")
+	for i := 0; i < len(la) && i < len(lb); i++ {
+		if la[i] != lb[i] {
+			return i + 1
+		}
+	}
+	if len(la) < len(lb) {
+		return len(la)
+	}
+	return len(lb)
+}
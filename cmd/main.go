@@ -11,11 +11,19 @@ import (
 	"polinco/php"
 	"polinco/po"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 var gitCommit string
 
+// *.po ファイルのチェック内容
+var poOption struct {
+	parser         *po.Parser
+	checkReference bool
+}
+
 type strsslice []string
 
 func (s *strsslice) String() string {
@@ -33,35 +41,107 @@ func (s *strsslice) Set(value string) error {
 	return nil
 }
 
-func main() {
+// サブコマンド. 無指定の場合は lint
+var commands = map[string]func(args []string) int{
+	"compile": compileMain,
+	"extract": extractMain,
+	"fmt":     fmtMain,
+	"merge":   mergeMain,
+}
 
-	var (
-		// locale_dir    = flag.String("locale", "", "locale directory")
-		src_dir       = flag.String("src", "", "source directory")
-		parse_level   = flag.Int("parse-level", 0, "debug level")
-		parse_verbose = flag.Bool("parse-verbose", false, "verbose mode of parser")
-		verbose       = flag.Bool("verbose", false, "verbose mode of polinco")
-		strip_prefix  = flag.String("strip-prefix", "", "strip the specified prefix from file path in the report")
-	)
+// 各コマンド共通のオプション
+type commonFlags struct {
+	plugins       strsslice
+	reporter      *flagvar.ChoiceVar
+	parse_level   *int
+	parse_verbose *bool
+	verbose       *bool
+	strip_prefix  *string
+	check_ref     *bool
+	domain        *string
+	config        *string
+	classes       strsslice
+}
+
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	c := &commonFlags{
+		parse_level:   fs.Int("parse-level", 0, "debug level"),
+		parse_verbose: fs.Bool("parse-verbose", false, "verbose mode of parser"),
+		verbose:       fs.Bool("verbose", false, "verbose mode of polinco"),
+		strip_prefix:  fs.String("strip-prefix", "", "strip the specified prefix from file path in the report"),
+		check_ref:     fs.Bool("check-ref", false, "check that #: references in *.po files point to existing files"),
+		domain:        fs.String("default-domain", "", "domain of translation functions without a domain argument such as __() (default \"default\")"),
+		config:        fs.String("config", "", "JSON file declaring additional translation functions"),
+	}
 	reporters := []string{"plain", "github"} // , "json", "csv"}
-	opt_reporter := flagvar.NewChoiceVar(reporters[0], reporters)
-	flag.Var(opt_reporter, "reporter", fmt.Sprintf("reporter (choose from %v)", reporters))
+	c.reporter = flagvar.NewChoiceVar(reporters[0], reporters)
+	fs.Var(c.reporter, "reporter", fmt.Sprintf("reporter (choose from %v)", reporters))
 
-	var plugins strsslice
 	// *.po ファイルを読み込むプラグイン名
-	flag.Var(&plugins, "plugin", "plugin name")
+	fs.Var(&c.plugins, "plugin", "plugin name")
 
-	flag.Parse()
+	// $this->__d() などを翻訳関数として扱うクラス
+	fs.Var(&c.classes, "translation-class", "class whose methods named like translation functions (e.g. I18n::__d) are checked too")
+	return c
+}
 
+func (c *commonFlags) newLinter() *com.Linter {
 	logger := log.New(log.Writer(), "", log.LstdFlags|log.Lshortfile|log.Lmsgprefix)
 	logger.Printf("start polint! version=%s\n", gitCommit)
 
-	reporter := com.NewReporter(opt_reporter.String())
-	reporter.SetStripPrefix(*strip_prefix)
+	reporter := com.NewReporter(c.reporter.String())
+	reporter.SetStripPrefix(*c.strip_prefix)
 	linter := &com.Linter{Reporter: reporter, Logger: logger}
-	linter.SetVerbose(*verbose)
+	linter.SetVerbose(*c.verbose)
+
+	poOption.parser = &po.Parser{DebugLevel: *c.parse_level, ErrorVerbose: *c.parse_verbose}
+	poOption.checkReference = *c.check_ref
+	return linter
+}
+
+// PHP ソースの翻訳関数の解析の設定.
+// -default-domain は設定ファイルより優先する
+func (c *commonFlags) newPHPConfig(linter *com.Linter) *php.Config {
+	config := php.NewConfig()
+	if *c.config != "" {
+		r, err := os.Open(*c.config)
+		if err != nil {
+			linter.Reporter.ReportError(*c.config, 0, 0, com.LevelError, err.Error())
+			return nil
+		}
+		defer r.Close()
+
+		if err := config.Load(r); err != nil {
+			linter.Reporter.ReportError(*c.config, 0, 0, com.LevelError, err.Error())
+			return nil
+		}
+	}
+	if *c.domain != "" {
+		config.DefaultDomain = *c.domain
+	}
+	for _, class := range c.classes {
+		config.AddClass(class)
+	}
+	return config
+}
 
-	po.Debug(*parse_level, *parse_verbose)
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
+	var (
+		// locale_dir    = flag.String("locale", "", "locale directory")
+		src_dir = flag.String("src", "", "source directory")
+	)
+	opts := addCommonFlags(flag.CommandLine)
+
+	flag.Parse()
+
+	linter := opts.newLinter()
+	plugins := opts.plugins
 
 	entriesDict := make(map[string]map[string]*com.PoEntry)
 	for _, plugin := range plugins {
@@ -87,7 +167,11 @@ func main() {
 	}
 
 	if *src_dir != "" {
-		err := php.ParsePHPDir(linter, *src_dir, entriesDict)
+		config := opts.newPHPConfig(linter)
+		if config == nil {
+			os.Exit(1)
+		}
+		err := php.ParsePHPDir(linter, config, *src_dir, entriesDict)
 		if err != nil {
 			os.Exit(1)
 		}
@@ -126,6 +210,18 @@ func parsePoLocale(linter *com.Linter, locale_dir string) (map[string]map[string
 			return nil, err
 		}
 
+		// *.mo しか無いドメインは *.mo を読む
+		mofiles, err := filepath.Glob(dir + "/*.mo")
+		if err != nil {
+			linter.Logger.Fatal(err)
+			return nil, err
+		}
+		for _, mofile := range mofiles {
+			if _, err := os.Stat(strings.TrimSuffix(mofile, ".mo") + ".po"); err != nil {
+				files = append(files, mofile)
+			}
+		}
+
 		if len(files) == 0 {
 			continue
 		}
@@ -157,7 +253,7 @@ func parsePoDir(linter *com.Linter, files []string) (map[string]map[string]*com.
 
 	for _, file := range files {
 		// file = $path/plugin_name.po から plugin_name を取得
-		plugin_name := strings.TrimSuffix(filepath.Base(file), ".po")
+		plugin_name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".po"), ".mo")
 
 		linter.Dprintf("%s: %s start\n", plugin_name, file)
 
@@ -179,11 +275,11 @@ func parsePoDir(linter *com.Linter, files []string) (map[string]map[string]*com.
 			return nil, err
 		}
 
-		for msgid, entry := range _id2 {
-			if e, ok := id2entry[msgid]; ok {
-				linter.Reporter.ReportError(file, entry.Pos.Line, entry.Pos.Column, com.LevelError, fmt.Sprintf("1duplicate msgid: %s=%s [%s:%d:%s]", msgid, entry.MsgStr, e.Filename, e.Pos.Line, e.MsgStr))
+		for key, entry := range _id2 {
+			if e, ok := id2entry[key]; ok {
+				linter.Reporter.ReportError(file, entry.Pos.Line, entry.Pos.Column, com.LevelError, fmt.Sprintf("1duplicate msgid: %s=%s [%s:%d:%s]", entry.Label(), entry.MsgStr, e.Filename, e.Pos.Line, e.MsgStr))
 			} else {
-				id2entry[msgid] = entry
+				id2entry[key] = entry
 			}
 		}
 
@@ -209,17 +305,127 @@ func parsePoDir(linter *com.Linter, files []string) (map[string]map[string]*com.
  * 単一ファイル内でのチェック
  */
 func parsePoFile(linter *com.Linter, filename string) (map[string]*com.PoEntry, map[string]*com.PoEntry, error) {
-	r, err := os.Open(filename)
+	pofile := loadPoFile(linter, filename)
+	if pofile == nil {
+		return map[string]*com.PoEntry{}, map[string]*com.PoEntry{}, nil
+	}
+
+	// xxx.po の隣に xxx.mo があれば内容を比べる
+	if strings.HasSuffix(filename, ".po") {
+		mofile := strings.TrimSuffix(filename, ".po") + ".mo"
+		if _, err := os.Stat(mofile); err == nil {
+			checkStaleMo(linter, filename, pofile, mofile)
+		}
+	}
+	return checkPoFile(linter, filename, pofile)
+}
+
+/***
+ * *.po を更新したのに *.mo を作り直していないものを探す.
+ * タイムスタンプではなく内容を比較する
+ */
+func checkStaleMo(linter *com.Linter, filename string, pofile *po.File, mofilename string) {
+	r, err := os.Open(mofilename)
 	if err != nil {
-		linter.Logger.Fatal(err)
-		return nil, nil, err
+		linter.Reporter.ReportError(mofilename, 0, 0, com.LevelError, err.Error())
+		return
 	}
+	defer r.Close()
 
-	poEntries, err := po.ParsePo(r)
+	mofile, err := po.ReadMo(r)
+	if err != nil {
+		linter.Reporter.ReportError(mofilename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+
+	if pofile.Header != nil {
+		if mofile.Header == nil || mofile.Header.Entry.MsgStr != pofile.Header.Entry.MsgStr {
+			pos := pofile.Header.Entry.Pos
+			linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError,
+				fmt.Sprintf("stale .mo: header differs from %s", filepath.Base(mofilename)))
+		}
+	}
+
+	moentries := make(map[string]*com.PoEntry)
+	for _, e := range mofile.Entries {
+		moentries[e.Key()] = e
+	}
+
+	for _, entry := range pofile.Entries {
+		if !po.IsMoEntry(entry) {
+			continue
+		}
+		pos := entry.Pos
+		e, ok := moentries[entry.Key()]
+		if !ok {
+			linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError,
+				fmt.Sprintf("stale .mo: missing in %s: %s", filepath.Base(mofilename), entry.Label()))
+			continue
+		}
+		delete(moentries, entry.Key())
+
+		if e.MsgIDPlural != entry.MsgIDPlural {
+			linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError,
+				fmt.Sprintf("stale .mo: msgid_plural differs: %s: %s != %s", entry.Label(), entry.MsgIDPlural, e.MsgIDPlural))
+		} else if strings.Join(e.MsgStrForms(), "\x00") != strings.Join(entry.MsgStrForms(), "\x00") {
+			linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError,
+				fmt.Sprintf("stale .mo: msgstr differs: %s: %s != %s", entry.Label(), entry.MsgStr, e.MsgStr))
+		}
+	}
+
+	// *.mo にしか無いもの
+	only := make([]*com.PoEntry, 0, len(moentries))
+	for _, e := range moentries {
+		only = append(only, e)
+	}
+	sort.Slice(only, func(i, j int) bool {
+		return only[i].Pos.Offset < only[j].Pos.Offset
+	})
+	for _, e := range only {
+		linter.Reporter.ReportError(mofilename, 0, 0, com.LevelError,
+			fmt.Sprintf("stale .mo: not in %s: %s", filepath.Base(filename), e.Label()))
+	}
+}
+
+/***
+ * *.po または *.mo を読む. 読めない場合は報告して nil を返す
+ */
+func loadPoFile(linter *com.Linter, filename string) *po.File {
+	r, err := os.Open(filename)
 	if err != nil {
 		linter.Logger.Fatal(err)
-		return nil, nil, err
+		return nil
+	}
+	defer r.Close()
+
+	var pofile *po.File
+	if strings.HasSuffix(filename, ".mo") {
+		pofile, err = po.ReadMo(r)
+		if err != nil {
+			linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+			return nil
+		}
+	} else {
+		// 構文エラーは Diagnostics で報告し, 読めたエントリのチェックを続ける.
+		// 読み込みに失敗したときは File が無い
+		pofile, err = poOption.parser.Parse(r)
+		if pofile == nil {
+			linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+			return nil
+		}
+	}
+	for _, d := range pofile.Diagnostics {
+		linter.Reporter.ReportError(filename, d.Pos.Line, d.Pos.Column, d.Level, d.Msg)
 	}
+	return pofile
+}
+
+func checkPoFile(linter *com.Linter, filename string, pofile *po.File) (map[string]*com.PoEntry, map[string]*com.PoEntry, error) {
+	poEntries := pofile.Entries
+
+	// plugin/resources/locales/ja_JP/xxx.po
+	locale := filepath.Base(filepath.Dir(filename))
+	nplurals := checkHeader(linter, filename, locale, pofile)
 
 	str2entry := make(map[string]*com.PoEntry)
 	id2entry := make(map[string]*com.PoEntry)
@@ -233,58 +439,252 @@ func parsePoFile(linter *com.Linter, filename string) (map[string]*com.PoEntry,
 			panic("bug!")
 		}
 
-		if e, ok := str2entry[entry.MsgStr]; ok {
+		if entry.Obsolete {
+			// 廃止されたエントリはチェックしない
+			continue
+		}
+
+		for _, msgstr := range entry.MsgStrForms() {
+			e, ok := str2entry[msgstr]
+			if !ok {
+				str2entry[msgstr] = entry
+				continue
+			}
+			if e == entry {
+				// 同一エントリ内の複数形
+				continue
+			}
 			if e.Filename == "" || e.Filename != entry.Filename {
 				panic("bug!")
 			}
-			if entry.MsgID+"." != e.MsgID &&
+			// msgctxt だけが異なる場合は同じ訳になってもよい
+			if entry.MsgID != e.MsgID &&
+				entry.MsgID+"." != e.MsgID &&
 				entry.MsgID != e.MsgID+"." &&
 				entry.MsgID+"s" != e.MsgID &&
 				entry.MsgID != e.MsgID+"s" {
 				linter.Reporter.ReportError(
 					filename, entry.Pos.Line, entry.Pos.Column, com.LevelWarning,
-					fmt.Sprintf("2duplicate msgstr: %s=%s [%d:%s]", entry.MsgID, entry.MsgStr, e.Pos.Line, e.MsgID))
+					fmt.Sprintf("2duplicate msgstr: %s=%s [%d:%s]", entry.MsgID, msgstr, e.Pos.Line, e.MsgID))
 			} else {
 				linter.Reporter.ReportError(
 					filename, entry.Pos.Line, entry.Pos.Column, com.LevelInfo,
-					fmt.Sprintf("2duplicate msgstr x similar msgid: %s=%s [%d:%s]", entry.MsgID, entry.MsgStr, e.Pos.Line, e.MsgID))
+					fmt.Sprintf("2duplicate msgstr x similar msgid: %s=%s [%d:%s]", entry.MsgID, msgstr, e.Pos.Line, e.MsgID))
 			}
-		} else {
-			str2entry[entry.MsgStr] = entry
 		}
 
-		if e, ok := id2entry[entry.MsgID]; ok {
+		if e, ok := id2entry[entry.Key()]; ok {
 			linter.Reporter.ReportError(
 				filename, entry.Pos.Line, entry.Pos.Column, com.LevelError,
-				fmt.Sprintf("2duplicate msgid: %s=%s [%d:%s]", entry.MsgID, entry.MsgStr, e.Pos.Line, e.MsgID))
+				fmt.Sprintf("2duplicate msgid: %s=%s [%d:%s]", entry.Label(), entry.MsgStr, e.Pos.Line, e.MsgID))
 		} else {
-			id2entry[entry.MsgID] = entry
+			id2entry[entry.Key()] = entry
 		}
 
-		b, err := regexp.MatchString(`^[a-zA-Z0-9 {}()<>:/=%[\]'"?,._\\-]*$`, entry.MsgID)
-		if err != nil {
-			return nil, nil, err
+		if entry.IsPlural() && nplurals > 0 && len(entry.MsgStrs) != nplurals {
+			linter.Reporter.ReportError(
+				filename, entry.Pos.Line, entry.Pos.Column, com.LevelError,
+				fmt.Sprintf("number of msgstr[N] should be nplurals=%d, actual=%d: %s", nplurals, len(entry.MsgStrs), entry.Label()))
 		}
 
-		if !b {
+		if entry.IsFuzzy() {
 			linter.Reporter.ReportError(
-				filename, entry.Pos.Line, entry.Pos.Column, com.LevelError,
-				fmt.Sprintf("invalid msgid: '%s'", entry.MsgID))
+				filename, entry.Pos.Line, entry.Pos.Column, com.LevelWarning,
+				fmt.Sprintf("fuzzy entry is treated as untranslated: %s", entry.Label()))
+		}
+
+		if poOption.checkReference {
+			checkReferences(linter, filename, entry)
 		}
 
-		for i := 0; i < 10; i++ {
-			tag := fmt.Sprintf("{%d}", i)
-			if strings.Contains(entry.MsgID, tag) != strings.Contains(entry.MsgStr, tag) {
+		msgids := []string{entry.MsgID}
+		if entry.IsPlural() {
+			msgids = append(msgids, entry.MsgIDPlural)
+		}
+		for _, msgid := range msgids {
+			// エスケープされた表記で判定する
+			b, err := regexp.MatchString(`^[a-zA-Z0-9 {}()<>:/=%[\]'"?,._\\-]*$`, po.Escape(msgid))
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if !b {
 				linter.Reporter.ReportError(
-					filename, entry.Pos.Line, entry.Pos.Column, com.LevelWarning,
-					fmt.Sprintf("missing `%s` in msgid<%s> or msgstr<%s>", tag, entry.MsgID, entry.MsgStr))
+					filename, entry.Pos.Line, entry.Pos.Column, com.LevelError,
+					fmt.Sprintf("invalid msgid: '%s'", msgid))
+			}
+		}
+
+		for n, msgstr := range entry.MsgStrForms() {
+			msgid := entry.MsgIDForm(n)
+			for i := 0; i < 10; i++ {
+				tag := fmt.Sprintf("{%d}", i)
+				if strings.Contains(msgid, tag) != strings.Contains(msgstr, tag) {
+					linter.Reporter.ReportError(
+						filename, entry.Pos.Line, entry.Pos.Column, com.LevelWarning,
+						fmt.Sprintf("missing `%s` in msgid<%s> or msgstr<%s>", tag, msgid, msgstr))
+				}
 			}
 		}
 	}
 
+	// 廃止されたエントリは, 有効なエントリが無い場合のみ登録する.
+	// __d() から参照されたことを検出するため.
+	// 有効なエントリとして復活していれば, #~ の方は消し忘れ
+	for _, entry := range poEntries {
+		if !entry.Obsolete {
+			continue
+		}
+		if e, ok := id2entry[entry.Key()]; ok && !e.Obsolete {
+			linter.Reporter.ReportError(
+				filename, entry.Pos.Line, entry.Pos.Column, com.LevelWarning,
+				fmt.Sprintf("obsolete entry is active again at line %d, should be removed: %s", e.Pos.Line, entry.Label()))
+			continue
+		}
+		id2entry[entry.Key()] = entry
+	}
+
 	return id2entry, str2entry, nil
 }
 
+/***
+ * ヘッダのチェック.
+ * 複数形の数 nplurals を返す. 不明な場合は 0
+ */
+func checkHeader(linter *com.Linter, filename, locale string, pofile *po.File) int {
+	nplurals := 0
+	if v, ok := po.DefaultPluralForms(locale); ok {
+		nplurals, _, _ = po.ParsePluralForms(v)
+	}
+
+	header := pofile.Header
+	if header == nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelWarning, "missing header entry (msgid \"\")")
+		return nplurals
+	}
+	pos := header.Entry.Pos
+
+	if _, ok := header.Get("Content-Type"); !ok {
+		linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelWarning, "missing Content-Type in header")
+	} else if charset := header.Charset(); !strings.EqualFold(charset, "UTF-8") && !strings.EqualFold(charset, "UTF8") {
+		linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError, fmt.Sprintf("charset should be UTF-8: %s", charset))
+	}
+
+	if lang := header.Language(); lang == "" {
+		linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelWarning, "missing Language in header")
+	} else if !matchLanguage(lang, locale) {
+		linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError, fmt.Sprintf("Language does not match the locale directory: %s != %s", lang, locale))
+	}
+
+	if v, ok := header.Get("Plural-Forms"); ok {
+		if n, plural, ok := po.ParsePluralForms(v); !ok {
+			linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError, fmt.Sprintf("invalid Plural-Forms: %s", v))
+		} else {
+			checkPluralForms(linter, filename, locale, header, n, plural)
+			nplurals = n
+		}
+	} else {
+		for _, entry := range pofile.Entries {
+			if entry.IsPlural() && !entry.Obsolete {
+				linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError, "missing Plural-Forms in header, but plural entries exist")
+				break
+			}
+		}
+	}
+
+	if v, ok := header.Get("PO-Revision-Date"); !ok || v == "" || strings.HasPrefix(v, "YEAR-") {
+		linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelWarning, "missing PO-Revision-Date in header")
+	}
+	return nplurals
+}
+
+// n = 0..pluralSimulateMax で Plural-Forms を評価する
+const pluralSimulateMax = 200
+
+func checkPluralForms(linter *com.Linter, filename, locale string, header *po.Header, nplurals int, plural string) {
+	pos := header.Entry.Pos
+	expr, err := po.ParsePluralExpr(plural)
+	if err != nil {
+		panic("bug!")
+	}
+
+	hist := expr.Histogram(pluralSimulateMax)
+	for i := 0; i < nplurals; i++ {
+		if hist[uint64(i)] == 0 {
+			linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelWarning,
+				fmt.Sprintf("msgstr[%d] is never selected for n=0..%d: %s", i, pluralSimulateMax, plural))
+		}
+	}
+	for n := uint64(0); n <= pluralSimulateMax; n++ {
+		if k := expr.Eval(n); k >= uint64(nplurals) {
+			linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError,
+				fmt.Sprintf("plural=%s returns %d >= nplurals=%d for n=%d", plural, k, nplurals, n))
+			break
+		}
+	}
+
+	v, ok := po.DefaultPluralForms(locale)
+	if !ok {
+		return
+	}
+	dn, dplural, _ := po.ParsePluralForms(v)
+	dexpr, _ := po.ParsePluralExpr(dplural)
+	if dn != nplurals {
+		linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelError,
+			fmt.Sprintf("nplurals=%d does not match the language %s: expected %s", nplurals, locale, v))
+	} else if n, ok := expr.Equal(dexpr, pluralSimulateMax); !ok {
+		linter.Reporter.ReportError(filename, pos.Line, pos.Column, com.LevelWarning,
+			fmt.Sprintf("plural=%s does not match the language %s for n=%d: expected %s", plural, locale, n, v))
+	}
+}
+
+// Language: ja, ja_JP, ja-JP などとディレクトリ名 ja_JP を比較する
+func matchLanguage(lang, locale string) bool {
+	lang = strings.ReplaceAll(lang, "-", "_")
+	locale = strings.ReplaceAll(locale, "-", "_")
+	if strings.EqualFold(lang, locale) {
+		return true
+	}
+	// 言語部分のみ
+	if n := strings.Index(locale, "_"); n > 0 && !strings.Contains(lang, "_") {
+		return strings.EqualFold(lang, locale[:n])
+	}
+	return false
+}
+
+// plugin/resources/locales/ja_JP/xxx.po から plugin を得る
+func pluginDir(pofile string) string {
+	dir := pofile
+	for i := 0; i < 4; i++ {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+/***
+ * #: の参照先のファイルが存在するか
+ */
+func checkReferences(linter *com.Linter, filename string, entry *com.PoEntry) {
+	base := pluginDir(filename)
+	for _, ref := range entry.References {
+		path := ref
+		if n := strings.LastIndex(ref, ":"); n > 0 {
+			if _, err := strconv.Atoi(ref[n+1:]); err == nil {
+				path = ref[:n]
+			}
+		}
+
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(base, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			linter.Reporter.ReportError(
+				filename, entry.Pos.Line, entry.Pos.Column, com.LevelWarning,
+				fmt.Sprintf("reference not found: %s [%s]", ref, entry.Label()))
+		}
+	}
+}
+
 func comparePo(po1, po2 map[string]map[string]*com.PoEntry) bool {
 	if len(po1) != len(po2) {
 		return false
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"polinco/com"
+	"polinco/php"
+	"polinco/po"
+	"strings"
+)
+
+/***
+ * polinco merge: msgmerge の代わりに -src から抽出した msgid で
+ * plugin/resources/locales/<locale>/<domain>.po を更新する
+ */
+func mergeMain(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	src_dir := fs.String("src", "", "source directory")
+	dry_run := fs.Bool("dry-run", false, "report changes without rewriting files")
+	fs.Parse(args)
+
+	linter := opts.newLinter()
+	if *src_dir == "" {
+		linter.Reporter.ReportError("", 0, 0, com.LevelError, "-src is required")
+		return 1
+	}
+
+	config := opts.newPHPConfig(linter)
+	if config == nil {
+		return 1
+	}
+	catalog := php.NewCatalog("")
+	if err := php.ExtractPHPDir(linter, config, *src_dir, catalog); err != nil {
+		return 1
+	}
+
+	for _, plugin := range opts.plugins {
+		files, err := filepath.Glob(plugin + "/resources/locales/*/*.po")
+		if err != nil {
+			linter.Logger.Fatal(err)
+			return 1
+		}
+
+		for _, file := range files {
+			domain := strings.TrimSuffix(filepath.Base(file), ".po")
+			template, ok := catalog.Domains[domain]
+			if !ok {
+				// 別の -src で使われているかもしれないので, すべて #~ にはしない
+				linter.Dprintf("merge %s: no msgid for domain %s\n", file, domain)
+				continue
+			}
+			mergePoFile(linter, file, relativeReferences(template, plugin), *dry_run)
+		}
+	}
+
+	if linter.Reporter.CountError() > 0 {
+		fmt.Printf("exit ... merge err %d\n", linter.Reporter.CountError())
+		return 1
+	}
+	return 0
+}
+
+func mergePoFile(linter *com.Linter, filename string, template []*com.PoEntry, dry_run bool) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+
+	pofile, err := poOption.parser.Parse(bytes.NewReader(src))
+	if pofile == nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+	if len(pofile.Diagnostics) > 0 {
+		// fmt と同じく, 読めなかった部分や不正なエスケープが変わってしまうので書き直さない
+		for _, d := range pofile.Diagnostics {
+			linter.Reporter.ReportError(filename, d.Pos.Line, d.Pos.Column, d.Level, d.Msg)
+		}
+		return
+	}
+
+	stats := po.Merge(pofile, template)
+	if dry_run {
+		// -verbose が無くても何が変わるかを示す
+		fmt.Printf("%s: %d added, %d updated, %d fuzzy, %d obsolete, %d revived\n",
+			filename, stats.Added, stats.Updated, stats.Fuzzy, stats.Obsolete, stats.Revived)
+	} else {
+		linter.Dprintf("merge %s: %d matched, %d updated, %d fuzzy, %d added, %d obsolete, %d revived\n",
+			filename, stats.Matched, stats.Updated, stats.Fuzzy, stats.Added, stats.Obsolete, stats.Revived)
+	}
+
+	var buf bytes.Buffer
+	if err := po.Write(&buf, pofile); err != nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+		return
+	}
+	if dry_run || bytes.Equal(buf.Bytes(), src) {
+		return
+	}
+	if err := writeFileAtomic(filename, buf.Bytes()); err != nil {
+		linter.Reporter.ReportError(filename, 0, 0, com.LevelError, err.Error())
+	}
+}
+
+// #: を -check-ref と同じく plugin ディレクトリからの相対パスにする.
+// template は変更しない
+func relativeReferences(template []*com.PoEntry, plugin string) []*com.PoEntry {
+	ret := make([]*com.PoEntry, 0, len(template))
+	for _, t := range template {
+		e := *t
+		e.References = make([]string, 0, len(t.References))
+		for _, ref := range t.References {
+			if rel, err := filepath.Rel(plugin, ref); err == nil && !strings.HasPrefix(rel, "..") {
+				ref = filepath.ToSlash(rel)
+			}
+			e.References = append(e.References, ref)
+		}
+		ret = append(ret, &e)
+	}
+	return ret
+}
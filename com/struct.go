@@ -7,11 +7,25 @@ import (
 )
 
 type PoEntry struct {
+	Context  string // msgctxt
 	MsgID    string
-	MsgStr   string
+	MsgStr   string // 複数形の場合は msgstr[0]
 	Pos      scanner.Position
 	Filename string
 	Called   int
+
+	MsgIDPlural string   // msgid_plural
+	MsgStrs     []string // msgstr[N]. 複数形でない場合は nil
+
+	Comments          []string // "# " 翻訳者コメント
+	ExtractedComments []string // "#." 抽出コメント
+	References        []string // "#:" 参照 (file:line)
+	Flags             []string // "#," fuzzy, php-format など
+	PrevContext       string   // "#| msgctxt"
+	PrevMsgID         string   // "#| msgid"
+	PrevMsgIDPlural   string   // "#| msgid_plural"
+
+	Obsolete bool // "#~" 廃止されたエントリ
 }
 
 type Reporter interface {
@@ -36,3 +50,57 @@ func (l *Linter) Dprintf(fmt string, args ...any) {
 		l.Logger.Printf(fmt, args...)
 	}
 }
+
+// gettext と同様に msgctxt と msgid を EOT で連結したものを
+// カタログのキーとする. msgctxt が無い場合は msgid そのもの.
+func PoKey(context, msgid string) string {
+	if context == "" {
+		return msgid
+	}
+	return context + "\x04" + msgid
+}
+
+func (e *PoEntry) Key() string {
+	return PoKey(e.Context, e.MsgID)
+}
+
+// エラーメッセージ用
+func (e *PoEntry) Label() string {
+	if e.Context == "" {
+		return e.MsgID
+	}
+	return e.MsgID + " (msgctxt=" + e.Context + ")"
+}
+
+func (e *PoEntry) IsPlural() bool {
+	return e.MsgStrs != nil
+}
+
+// msgstr[N] を含めたすべての訳
+func (e *PoEntry) MsgStrForms() []string {
+	if e.IsPlural() {
+		return e.MsgStrs
+	}
+	return []string{e.MsgStr}
+}
+
+// n 番目の訳に対応する原文
+func (e *PoEntry) MsgIDForm(n int) string {
+	if n > 0 && e.IsPlural() {
+		return e.MsgIDPlural
+	}
+	return e.MsgID
+}
+
+func (e *PoEntry) HasFlag(flag string) bool {
+	for _, f := range e.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *PoEntry) IsFuzzy() bool {
+	return e.HasFlag("fuzzy")
+}
@@ -0,0 +1,95 @@
+package php
+
+import (
+	"fmt"
+)
+
+// /////////////////////////////////////////////////////////////
+// 呼び出しの引数
+// /////////////////////////////////////////////////////////////
+
+// 呼び出しの引数 1 つ
+type argument struct {
+	name   string   // 名前付き引数 name: value の name. 位置で渡せば ""
+	spread bool     // ...$args
+	tokens []*Token // 値の式
+}
+
+// '(' の直後からの tokens を対応する ')' まで引数ごとに読む.
+// ')' が無ければ false
+func parseArgs(tokens []*Token) ([]*argument, bool) {
+	pieces, ok := splitArgs(tokens)
+	args := make([]*argument, 0, len(pieces))
+	for _, p := range pieces {
+		a := &argument{tokens: p}
+		switch {
+		case len(p) >= 2 && (p[0].isType(TOKEN_IDENTIFIER) || p[0].isType(TOKEN_KEYWORD)) && p[1].is(TOKEN_SYMBOL, ":"):
+			a.name, a.tokens = p[0].Value, p[2:]
+		case len(p) >= 1 && p[0].is(TOKEN_SYMBOL, "..."):
+			a.spread, a.tokens = true, p[1:]
+		}
+		args = append(args, a)
+	}
+	return args, ok
+}
+
+// 関数の引数の位置に並べた引数
+type boundArgs struct {
+	values [][]*Token  // 位置ごとの値. 渡していない位置は nil
+	rest   []*argument // Function.Args 以降に渡した値. 可変長引数に入る名前付き引数も含む
+}
+
+// pos 番目の引数の値. 渡していなければ nil
+func (b *boundArgs) value(pos int) []*Token {
+	if pos < 0 || pos >= len(b.values) {
+		return nil
+	}
+	return b.values[pos]
+}
+
+// args を fn の引数の位置に並べる.
+// ...$args が msgid などを埋める, 引数の名前が分からないなど
+// 位置を決められなければ nil, nil
+func bindArgs(fn *Function, args []*argument) (*boundArgs, error) {
+	b := &boundArgs{values: make([][]*Token, 0, len(args))}
+	named, spread := false, false
+	for i, a := range args {
+		switch {
+		case a.name != "":
+			named = true
+			p := fn.paramIndex(a.name)
+			if p < 0 && fn.Params == nil {
+				return nil, nil
+			}
+			if p < 0 && fn.Args < 0 {
+				return nil, fmt.Errorf("unknown named parameter $%s", a.name)
+			}
+			if p < 0 || fn.Args >= 0 && p >= fn.Args {
+				b.rest = append(b.rest, a)
+				continue
+			}
+			for len(b.values) <= p {
+				b.values = append(b.values, nil)
+			}
+			if b.values[p] != nil {
+				return nil, fmt.Errorf("named parameter $%s overwrites previous argument", a.name)
+			}
+			b.values[p] = a.tokens
+		case named:
+			return nil, fmt.Errorf("cannot use positional argument after named argument")
+		case a.spread:
+			if i < fn.required() {
+				return nil, nil
+			}
+			spread = true
+			b.rest = append(b.rest, a)
+		case spread:
+			return nil, fmt.Errorf("cannot use positional argument after argument unpacking")
+		case fn.Args >= 0 && i >= fn.Args:
+			b.rest = append(b.rest, a)
+		default:
+			b.values = append(b.values, a.tokens)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,379 @@
+package php
+
+import (
+	"strings"
+)
+
+// /////////////////////////////////////////////////////////////
+// ファイル内の定数伝播
+// /////////////////////////////////////////////////////////////
+
+// ファイル内で値が文字列リテラルに決まる変数と定数.
+// 代入の順序は見ないので, 異なる値が代入される変数は解決しない
+type constants struct {
+	defines     map[string]string // define() とクラス外の const
+	classConsts map[string]string // "Class::NAME"
+	vars        map[varKey]string // 関数内 (またはクラス外) のローカル変数
+	bad         map[string]bool   // 値が 1 つに決まらないもの. defines などと同じキー
+	badVars     map[varKey]bool
+
+	funcScope []int    // tokens[i] を含む関数の番号. 関数の外は 0
+	class     []string // tokens[i] を含むクラスの名前
+}
+
+type varKey struct {
+	scope int
+	name  string // $name
+}
+
+// 代入したのと同じ扱いにする演算子
+var assignOps = map[string]bool{
+	".=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true,
+	"**=": true, "??=": true, "&=": true, "|=": true, "^=": true, "<<=": true, ">>=": true,
+	"++": true, "--": true, "[": true,
+}
+
+func newConstants(tokens []*Token) *constants {
+	c := &constants{
+		defines:     make(map[string]string),
+		classConsts: make(map[string]string),
+		vars:        make(map[varKey]string),
+		bad:         make(map[string]bool),
+		badVars:     make(map[varKey]bool),
+		funcScope:   make([]int, len(tokens)),
+		class:       make([]string, len(tokens)),
+	}
+
+	type scope struct {
+		depth int // 開始の { の深さ
+		fn    int // 関数なら番号, クラスなら -1
+		class string
+	}
+	stack := make([]scope, 0)
+	depth := 0
+	nfunc := 0
+	inParams := false // function の引数
+	pendingFunc := false
+	pendingClass := false
+	className := ""
+
+	for i, t := range tokens {
+		fn, class := 0, ""
+		for _, s := range stack {
+			if s.fn >= 0 {
+				fn = s.fn
+			} else {
+				class = s.class
+			}
+		}
+		if inParams {
+			// 引数は関数の中
+			fn = nfunc
+		}
+		c.funcScope[i], c.class[i] = fn, class
+
+		switch {
+		case t.isType(TOKEN_KEYWORD) && strings.EqualFold(t.Value, "function"):
+			nfunc++
+			pendingFunc, inParams = true, true
+		case isClassKeyword(t) && !(i > 0 && (tokens[i-1].is(TOKEN_SYMBOL, "::") || tokens[i-1].is(TOKEN_SYMBOL, "->"))):
+			pendingClass = true
+			className = ""
+			if i+1 < len(tokens) && tokens[i+1].isType(TOKEN_IDENTIFIER) {
+				className = tokens[i+1].Value
+			} else if i > 0 && tokens[i-1].isType(TOKEN_KEYWORD) && strings.EqualFold(tokens[i-1].Value, "new") {
+				className = anonymousClass
+			}
+		case t.is(TOKEN_SYMBOL, "{"):
+			depth++
+			if pendingFunc {
+				stack = append(stack, scope{depth: depth, fn: nfunc})
+				pendingFunc, inParams = false, false
+			} else if pendingClass {
+				stack = append(stack, scope{depth: depth, fn: -1, class: className})
+				pendingClass = false
+			}
+		case t.is(TOKEN_SYMBOL, "}"):
+			if len(stack) > 0 && stack[len(stack)-1].depth == depth {
+				stack = stack[:len(stack)-1]
+			}
+			depth--
+		case t.is(TOKEN_SYMBOL, ";"):
+			// 抽象メソッド
+			pendingFunc, inParams = false, false
+		case t.isType(TOKEN_IDENTIFIER) && strings.EqualFold(t.Value, "foreach") && i+1 < len(tokens) && tokens[i+1].is(TOKEN_SYMBOL, "("):
+			// foreach (... as $k => $v) の $k, $v. use A as B; などの as は関係ない
+			end := closing(tokens, i+1)
+			for j := i + 2; j < end; j++ {
+				if tokens[j].isType(TOKEN_IDENTIFIER) && strings.EqualFold(tokens[j].Value, "as") {
+					c.markBad(tokens, j+1, end, fn)
+					break
+				}
+			}
+		case t.isType(TOKEN_KEYWORD) && strings.EqualFold(t.Value, "fn"):
+			// fn($v) => ... の引数. 外の変数と同じスコープで数える
+			open := i + 1
+			if open < len(tokens) && tokens[open].is(TOKEN_SYMBOL, "&") {
+				open++
+			}
+			if open < len(tokens) && tokens[open].is(TOKEN_SYMBOL, "(") {
+				c.markBad(tokens, open, closing(tokens, open), fn)
+			}
+		case t.isType(TOKEN_IDENTIFIER) && strings.EqualFold(t.Value, "list") && i+1 < len(tokens) && tokens[i+1].is(TOKEN_SYMBOL, "("):
+			// list($a, $b) = ...
+			if end := closing(tokens, i+1); end >= 0 && end+1 < len(tokens) && tokens[end+1].is(TOKEN_SYMBOL, "=") {
+				c.markBad(tokens, i+1, end, fn)
+			}
+		case t.is(TOKEN_SYMBOL, "[") && !(i > 0 && isIndexable(tokens[i-1])):
+			// [$a, $b] = ...
+			if end := closing(tokens, i); end >= 0 && end+1 < len(tokens) && tokens[end+1].is(TOKEN_SYMBOL, "=") {
+				c.markBad(tokens, i, end, fn)
+			}
+		case t.isType(TOKEN_IDENTIFIER) && strings.EqualFold(t.Value, "define"):
+			c.addDefine(tokens, i)
+		case t.isType(TOKEN_IDENTIFIER) && strings.EqualFold(t.Value, "const"):
+			c.addConst(tokens, i, class)
+		case t.isType(TOKEN_VARIABLE):
+			c.addVar(tokens, i, fn, inParams)
+		}
+	}
+	return c
+}
+
+// tokens[from:to] の変数を値が 1 つに決まらないものにする
+func (c *constants) markBad(tokens []*Token, from, to, fn int) {
+	if to < 0 {
+		return
+	}
+	for _, t := range tokens[from:to] {
+		if t.isType(TOKEN_VARIABLE) {
+			c.badVars[varKey{scope: fn, name: t.Value}] = true
+		}
+	}
+}
+
+// tokens[open] の括弧に対応する閉じ括弧の位置. 無ければ -1
+func closing(tokens []*Token, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		if !tokens[i].isType(TOKEN_SYMBOL) {
+			continue
+		}
+		switch tokens[i].Value {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// 直後の [ が添字になるトークン. $a[0], f()[0], $a[0][1] など
+func isIndexable(t *Token) bool {
+	if t.isType(TOKEN_VARIABLE) || t.isType(TOKEN_IDENTIFIER) || t.isString() {
+		return true
+	}
+	return t.is(TOKEN_SYMBOL, ")") || t.is(TOKEN_SYMBOL, "]") || t.is(TOKEN_SYMBOL, "}")
+}
+
+// new class { ... } のクラス名. PHP の実行時の名前も class@anonymous で始まる
+const anonymousClass = "class@anonymous"
+
+// class, interface, trait, enum
+func isClassKeyword(t *Token) bool {
+	if t.isType(TOKEN_KEYWORD) && strings.EqualFold(t.Value, "class") {
+		return true
+	}
+	if !t.isType(TOKEN_IDENTIFIER) {
+		return false
+	}
+	switch strings.ToLower(t.Value) {
+	case "interface", "trait", "enum":
+		return true
+	}
+	return false
+}
+
+// 変数展開の無い文字列リテラル 1 つか
+func literalValue(tokens []*Token) (string, bool) {
+	if len(tokens) == 1 && tokens[0].isString() && !tokens[0].isInterpolated() {
+		return tokens[0].Str, true
+	}
+	return "", false
+}
+
+func (c *constants) set(m map[string]string, key, value string) {
+	if v, ok := m[key]; ok && v != value {
+		c.bad[key] = true
+	}
+	m[key] = value
+}
+
+// define('NAME', 'value')
+func (c *constants) addDefine(tokens []*Token, i int) {
+	if i+1 >= len(tokens) || !tokens[i+1].is(TOKEN_SYMBOL, "(") {
+		return
+	}
+	args, ok := splitArgs(tokens[i+2:])
+	if !ok || len(args) < 2 {
+		return
+	}
+	name, ok := literalValue(args[0])
+	if !ok {
+		return
+	}
+	if value, ok := literalValue(args[1]); ok {
+		c.set(c.defines, name, value)
+	} else {
+		c.bad[name] = true
+	}
+}
+
+// const A = 'a', B = 'b'; クラス内なら class 定数
+func (c *constants) addConst(tokens []*Token, i int, class string) {
+	end := i + 1
+	for end < len(tokens) && !tokens[end].is(TOKEN_SYMBOL, ";") {
+		end++
+	}
+
+	// , で分けて NAME = value を読む. 型の宣言があれば NAME の前にある
+	piece := make([]*Token, 0)
+	depth := 0
+	for j := i + 1; j <= end; j++ {
+		if j < end {
+			t := tokens[j]
+			if t.isType(TOKEN_SYMBOL) {
+				switch t.Value {
+				case "(", "[", "{":
+					depth++
+				case ")", "]", "}":
+					depth--
+				}
+			}
+			if !(depth == 0 && t.is(TOKEN_SYMBOL, ",")) {
+				piece = append(piece, t)
+				continue
+			}
+		}
+
+		eq := -1
+		for k, t := range piece {
+			if t.is(TOKEN_SYMBOL, "=") {
+				eq = k
+				break
+			}
+		}
+		if eq > 0 {
+			name := piece[eq-1].Value
+			m := c.defines
+			if class != "" {
+				name = class + "::" + name
+				m = c.classConsts
+			}
+			if value, ok := literalValue(piece[eq+1:]); ok {
+				c.set(m, name, value)
+			} else {
+				c.bad[name] = true
+			}
+		}
+		piece = make([]*Token, 0)
+	}
+}
+
+// $v = 'value'. それ以外の書き換えがあれば解決しない
+func (c *constants) addVar(tokens []*Token, i, fn int, param bool) {
+	key := varKey{scope: fn, name: tokens[i].Value}
+	if param || i > 0 && (tokens[i-1].is(TOKEN_SYMBOL, "&") || tokens[i-1].isType(TOKEN_IDENTIFIER) && isDeclarator(tokens[i-1].Value)) {
+		// 引数, 参照, global, static
+		c.badVars[key] = true
+		return
+	}
+	if i+1 >= len(tokens) || !tokens[i+1].isType(TOKEN_SYMBOL) {
+		return
+	}
+
+	op := tokens[i+1].Value
+	if assignOps[op] {
+		c.badVars[key] = true
+		return
+	}
+	if op != "=" {
+		return
+	}
+
+	end := i + 2
+	depth := 0
+	for ; end < len(tokens); end++ {
+		t := tokens[end]
+		if t.isType(TOKEN_SYMBOL) {
+			if depth == 0 && (t.Value == ";" || t.Value == "," || t.Value == ")" || t.Value == "]") {
+				break
+			}
+			switch t.Value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			}
+		}
+	}
+	value, ok := literalValue(tokens[i+2 : end])
+	if !ok {
+		c.badVars[key] = true
+		return
+	}
+	if v, ok := c.vars[key]; ok && v != value {
+		c.badVars[key] = true
+	}
+	c.vars[key] = value
+}
+
+func isDeclarator(s string) bool {
+	switch strings.ToLower(s) {
+	case "global", "static":
+		return true
+	}
+	return false
+}
+
+// tokens[i] の位置で arg の値が文字列に決まれば, その値の文字列トークン
+func (c *constants) resolve(arg []*Token, i int) (*Token, bool) {
+	value, ok := "", false
+	switch {
+	case len(arg) == 1 && arg[0].isType(TOKEN_VARIABLE):
+		key := varKey{scope: c.funcScope[i], name: arg[0].Value}
+		if !c.badVars[key] {
+			value, ok = c.vars[key]
+		}
+	case len(arg) == 1 && arg[0].isType(TOKEN_IDENTIFIER):
+		if !c.bad[arg[0].Value] {
+			value, ok = c.defines[arg[0].Value]
+		}
+	case len(arg) >= 3 && arg[len(arg)-2].is(TOKEN_SYMBOL, "::") && arg[len(arg)-1].isType(TOKEN_IDENTIFIER):
+		// self::NAME, static::NAME, \App\Foo::NAME
+		class := arg[len(arg)-3].Value
+		for _, t := range arg[:len(arg)-3] {
+			if !t.isType(TOKEN_IDENTIFIER) && !t.is(TOKEN_SYMBOL, "\\") {
+				return nil, false
+			}
+		}
+		if strings.EqualFold(class, "self") || strings.EqualFold(class, "static") {
+			class = c.class[i]
+		}
+		key := class + "::" + arg[len(arg)-1].Value
+		if class != "" && !c.bad[key] {
+			value, ok = c.classConsts[key]
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	t := NewToken(TOKEN_STRING1, value, arg[0].Lnum, arg[0].Col)
+	t.Str = value
+	return t, true
+}
@@ -0,0 +1,96 @@
+package php
+
+import (
+	"path/filepath"
+	"polinco/com"
+	"regexp"
+	"strconv"
+)
+
+// ソースから抽出したメッセージ
+type Catalog struct {
+	Domains map[string][]*com.PoEntry // ドメインごと. 最初に現れた順
+
+	// 参照 (#:) を base からの相対パスで書く
+	base  string
+	index map[string]map[string]*com.PoEntry
+}
+
+func NewCatalog(base string) *Catalog {
+	return &Catalog{
+		Domains: make(map[string][]*com.PoEntry),
+		base:    base,
+		index:   make(map[string]map[string]*com.PoEntry),
+	}
+}
+
+// CakePHP の {0}, {1}, ... 形式のプレースホルダ
+var placeholderRegexp = regexp.MustCompile(`\{[0-9]+\}`)
+
+// dirname 配下の *.php から翻訳関数の msgid を集める
+func ExtractPHPDir(linter *com.Linter, config *Config, dirname string, catalog *Catalog) error {
+	files, err := readPHPDir(linter, dirname)
+	if err != nil {
+		return err
+	}
+	config = findWrappers(linter, config, files)
+
+	unresolved := 0
+	for _, f := range files {
+		calls, n := findCalls(linter, config, f.filename, f.tokens)
+		unresolved += n
+		catalog.addCalls(config, f.filename, calls)
+	}
+	linter.Dprintf("%s: %d calls could not be resolved\n", dirname, unresolved)
+	return nil
+}
+
+func (c *Catalog) addCalls(config *Config, filename string, calls []*call) {
+	for _, call := range calls {
+		e := &com.PoEntry{Context: call.contextValue(), MsgID: stringValue(call.msgid)}
+		if call.plural != nil {
+			e.MsgIDPlural = stringValue(call.plural)
+			e.MsgStrs = []string{"", ""}
+		}
+		c.add(call.domainValue(config), e, c.reference(filename, call.tok.Lnum))
+	}
+}
+
+func (c *Catalog) reference(filename string, lnum int) string {
+	if c.base != "" {
+		if rel, err := filepath.Rel(c.base, filename); err == nil {
+			filename = rel
+		}
+	}
+	return filepath.ToSlash(filename) + ":" + strconv.Itoa(lnum)
+}
+
+// e を追加する. 同じ msgctxt, msgid のエントリがあれば参照だけ追加する
+func (c *Catalog) add(domain string, e *com.PoEntry, ref string) {
+	entries, ok := c.index[domain]
+	if !ok {
+		entries = make(map[string]*com.PoEntry)
+		c.index[domain] = entries
+	}
+
+	entry, ok := entries[e.Key()]
+	if !ok {
+		entry = e
+		entries[e.Key()] = entry
+		c.Domains[domain] = append(c.Domains[domain], entry)
+	} else if e.IsPlural() && !entry.IsPlural() {
+		// 単数形のみの呼び出しが先にあった
+		entry.MsgIDPlural = e.MsgIDPlural
+		entry.MsgStrs = e.MsgStrs
+	}
+	if !entry.HasFlag("php-format") && (placeholderRegexp.MatchString(entry.MsgID) || placeholderRegexp.MatchString(entry.MsgIDPlural)) {
+		entry.Flags = append(entry.Flags, "php-format")
+	}
+
+	for _, r := range entry.References {
+		if r == ref {
+			return
+		}
+	}
+	entry.References = append(entry.References, ref)
+}
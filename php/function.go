@@ -0,0 +1,271 @@
+package php
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// /////////////////////////////////////////////////////////////
+// 翻訳関数
+// /////////////////////////////////////////////////////////////
+
+// 翻訳関数の引数の並び. 位置は 0 から数える. 無い引数は -1
+type Function struct {
+	Domain  int `json:"domain"`  // ドメイン. -1 なら Config.DefaultDomain
+	Context int `json:"context"` // msgctxt
+	MsgID   int `json:"msgid"`
+	Plural  int `json:"plural"` // msgid_plural
+	Count   int `json:"count"`  // 複数形を選ぶ個数
+	Args    int `json:"args"`   // {0}, {1}, ... を置き換える値の始まり. -1 なら数を確認しない
+
+	// ドメインや msgctxt を引数で受け取らず, 決まった値を使うラッパー関数
+	FixedDomain  string `json:"fixed_domain"`
+	FixedContext string `json:"fixed_context"`
+
+	// 引数の名前 ($ を除く). 名前付き引数の位置を決める. nil なら名前付き引数は解析しない
+	Params []string `json:"params"`
+}
+
+// 設定ファイルで省略した位置は -1
+func (f *Function) UnmarshalJSON(data []byte) error {
+	type function Function
+	v := function{Domain: -1, Context: -1, MsgID: -1, Plural: -1, Count: -1, Args: -1}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	*f = Function(v)
+	return nil
+}
+
+func (f *Function) validate() error {
+	if f.MsgID < 0 {
+		return fmt.Errorf("msgid is required")
+	}
+	if f.Domain >= 0 && f.FixedDomain != "" {
+		return fmt.Errorf("domain and fixed_domain cannot be specified together")
+	}
+	if f.Context >= 0 && f.FixedContext != "" {
+		return fmt.Errorf("context and fixed_context cannot be specified together")
+	}
+	if (f.Plural < 0) != (f.Count < 0) {
+		return fmt.Errorf("plural and count must be specified together")
+	}
+	used := make(map[int]string)
+	for _, v := range []struct {
+		name string
+		pos  int
+	}{
+		{"domain", f.Domain}, {"context", f.Context}, {"msgid", f.MsgID},
+		{"plural", f.Plural}, {"count", f.Count},
+	} {
+		if v.pos < 0 {
+			continue
+		}
+		if name, ok := used[v.pos]; ok {
+			return fmt.Errorf("%s and %s are at the same position %d", name, v.name, v.pos)
+		}
+		used[v.pos] = v.name
+	}
+	if f.Args >= 0 && f.Args < f.required() {
+		return fmt.Errorf("args must be after the other arguments")
+	}
+	if f.Params != nil && len(f.Params) < f.required() {
+		return fmt.Errorf("params must name all of the arguments")
+	}
+	return nil
+}
+
+// 必須の引数の数
+func (f *Function) required() int {
+	n := 0
+	for _, i := range []int{f.Domain, f.Context, f.MsgID, f.Plural, f.Count} {
+		if i+1 > n {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// 必須の引数をすべて渡しているか
+func (f *Function) filled(b *boundArgs) bool {
+	for _, i := range []int{f.Domain, f.Context, f.MsgID, f.Plural, f.Count} {
+		if i >= 0 && b.value(i) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// 名前付き引数 name の位置. 無ければ -1
+func (f *Function) paramIndex(name string) int {
+	for i, p := range f.Params {
+		if p == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *Function) isPlural() bool {
+	return f.Plural >= 0
+}
+
+// CakePHP の翻訳関数
+var cakeFunctions = map[string]*Function{
+	"__":    {Domain: -1, Context: -1, MsgID: 0, Plural: -1, Count: -1, Args: 1, Params: []string{"singular", "args"}},
+	"__n":   {Domain: -1, Context: -1, MsgID: 0, Plural: 1, Count: 2, Args: 3, Params: []string{"singular", "plural", "count", "args"}},
+	"__d":   {Domain: 0, Context: -1, MsgID: 1, Plural: -1, Count: -1, Args: 2, Params: []string{"domain", "msg", "args"}},
+	"__dn":  {Domain: 0, Context: -1, MsgID: 1, Plural: 2, Count: 3, Args: 4, Params: []string{"domain", "singular", "plural", "count", "args"}},
+	"__x":   {Domain: -1, Context: 0, MsgID: 1, Plural: -1, Count: -1, Args: 2, Params: []string{"context", "singular", "args"}},
+	"__xn":  {Domain: -1, Context: 0, MsgID: 1, Plural: 2, Count: 3, Args: 4, Params: []string{"context", "singular", "plural", "count", "args"}},
+	"__dx":  {Domain: 0, Context: 1, MsgID: 2, Plural: -1, Count: -1, Args: 3, Params: []string{"domain", "context", "msg", "args"}},
+	"__dxn": {Domain: 0, Context: 1, MsgID: 2, Plural: 3, Count: 4, Args: 5, Params: []string{"domain", "context", "singular", "plural", "count", "args"}},
+
+	// CakePHP 2. 2 番目 (__dc は 3 番目) の引数はカテゴリ
+	"__c":  {Domain: -1, Context: -1, MsgID: 0, Plural: -1, Count: -1, Args: 2, Params: []string{"msg", "category", "args"}},
+	"__dc": {Domain: 0, Context: -1, MsgID: 1, Plural: -1, Count: -1, Args: 3, Params: []string{"domain", "msg", "category", "args"}},
+}
+
+// PHP ソースの解析の設定
+type Config struct {
+	DefaultDomain string `json:"default_domain"` // ドメインを指定しない __() などのドメイン
+
+	// 関数名から引数の並び. "name" はグローバル関数の呼び出しのみ.
+	// "->name" はメソッド呼び出し, "::name" は静的メソッド呼び出し,
+	// "Class::name" は Class の静的メソッドと Class の中の $this->name() の呼び出し.
+	// CakePHP の $this->Xxx->name() は XxxHelper か XxxComponent の "Class::name"
+	Functions map[string]*Function `json:"functions"`
+
+	// CakePHP の翻訳関数と同じ名前のメソッドも翻訳関数として扱うクラス
+	Classes []string `json:"classes"`
+}
+
+// CakePHP の翻訳関数を認識する設定
+func NewConfig() *Config {
+	c := &Config{DefaultDomain: "default", Functions: make(map[string]*Function)}
+	for name, f := range cakeFunctions {
+		c.Functions[name] = f
+	}
+	return c
+}
+
+func (c *Config) clone() *Config {
+	v := &Config{
+		DefaultDomain: c.DefaultDomain,
+		Functions:     make(map[string]*Function, len(c.Functions)),
+		Classes:       append([]string(nil), c.Classes...),
+	}
+	for name, f := range c.Functions {
+		v.Functions[name] = f
+	}
+	return v
+}
+
+// class のメソッド class::__d() なども翻訳関数として扱う
+func (c *Config) AddClass(class string) {
+	for name, f := range cakeFunctions {
+		c.Functions[class+"::"+name] = f
+	}
+	c.Classes = append(c.Classes, class)
+}
+
+// tokens[i] の識別子が翻訳関数の呼び出しか. class は tokens[i] を含むクラス.
+// 同じ名前でも関数の宣言や名前空間の付いた関数, 登録していないメソッドは除く
+func (c *Config) lookup(tokens []*Token, i int, class string) (*Function, bool) {
+	name := tokens[i].Value
+	prev := func(n int) *Token {
+		if i-n < 0 {
+			return &Token{}
+		}
+		return tokens[i-n]
+	}
+
+	switch p := prev(1); {
+	case isFunctionKeyword(p), p.is(TOKEN_SYMBOL, "&") && isFunctionKeyword(prev(2)):
+		// function __d(
+		return nil, false
+	case p.isType(TOKEN_KEYWORD) && strings.EqualFold(p.Value, "new"):
+		return nil, false
+	case p.is(TOKEN_SYMBOL, "\\") && prev(2).isType(TOKEN_IDENTIFIER):
+		// App\__d(
+		return nil, false
+	case p.is(TOKEN_SYMBOL, "->"), p.is(TOKEN_SYMBOL, "?->"):
+		if class != "" && prev(2).is(TOKEN_VARIABLE, "$this") {
+			if f, ok := c.Functions[class+"::"+name]; ok {
+				return f, true
+			}
+		}
+		if prev(2).isType(TOKEN_IDENTIFIER) && prev(3).is(TOKEN_SYMBOL, "->") && prev(4).is(TOKEN_VARIABLE, "$this") {
+			// $this->Html->name( はヘルパー, $this->Auth->name( はコンポーネント
+			for _, suffix := range []string{"Helper", "Component"} {
+				if f, ok := c.Functions[prev(2).Value+suffix+"::"+name]; ok {
+					return f, true
+				}
+			}
+		}
+		f, ok := c.Functions["->"+name]
+		return f, ok
+	case p.is(TOKEN_SYMBOL, "::"):
+		owner := prev(2).Value
+		if strings.EqualFold(owner, "self") || strings.EqualFold(owner, "static") {
+			owner = class
+		}
+		if owner != "" && prev(2).isType(TOKEN_IDENTIFIER) {
+			if f, ok := c.Functions[owner+"::"+name]; ok {
+				return f, true
+			}
+		}
+		f, ok := c.Functions["::"+name]
+		return f, ok
+	}
+	f, ok := c.Functions[name]
+	return f, ok
+}
+
+func isFunctionKeyword(t *Token) bool {
+	return t.isType(TOKEN_KEYWORD) && strings.EqualFold(t.Value, "function")
+}
+
+// JSON の設定ファイルを読み込み, 関数を追加する.
+//
+//	{
+//	  "default_domain": "default",
+//	  "functions": {
+//	    "nc_trans": {"domain": 0, "msgid": 1, "args": 2},
+//	    "->titleIcon": {"domain": 1, "msgid": 2},
+//	    "nc_menu": {"fixed_domain": "net_commons", "msgid": 0, "params": ["key"]}
+//	  },
+//	  "classes": ["I18nHelper"]
+//	}
+func (c *Config) Load(r io.Reader) error {
+	var v Config
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+
+	for name, f := range v.Functions {
+		if f == nil {
+			return fmt.Errorf("%s: no arguments", name)
+		}
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	for name, f := range v.Functions {
+		c.Functions[name] = f
+	}
+	for _, class := range v.Classes {
+		c.AddClass(class)
+	}
+	if v.DefaultDomain != "" {
+		c.DefaultDomain = v.DefaultDomain
+	}
+	return nil
+}
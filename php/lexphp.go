@@ -1,101 +1,595 @@
 package php
 
 import (
-	_ "fmt"
+	"bytes"
 	"io"
-	"text/scanner"
+	"strings"
+	"unicode/utf8"
 )
 
 type TokenType string
 
 const (
-	TOKEN_EOF        TokenType = "EOF"
-	TOKEN_IDENTIFIER TokenType = "IDENTIFIER"
-	TOKEN_NUMBER     TokenType = "NUMBER"
-	TOKEN_KEYWORD    TokenType = "KEYWORD"
-	TOKEN_SYMBOL     TokenType = "SYMBOL"
-	TOKEN_STRING1    TokenType = "STRING1"
-	TOKEN_STRING2    TokenType = "STRING2"
+	TOKEN_EOF         TokenType = "EOF"
+	TOKEN_IDENTIFIER  TokenType = "IDENTIFIER"
+	TOKEN_VARIABLE    TokenType = "VARIABLE" // $name
+	TOKEN_NUMBER      TokenType = "NUMBER"
+	TOKEN_KEYWORD     TokenType = "KEYWORD"
+	TOKEN_SYMBOL      TokenType = "SYMBOL"
+	TOKEN_STRING1     TokenType = "STRING1"     // '...'
+	TOKEN_STRING2     TokenType = "STRING2"     // "..."
+	TOKEN_HEREDOC     TokenType = "HEREDOC"     // <<<EOT
+	TOKEN_NOWDOC      TokenType = "NOWDOC"      // <<<'EOT'
+	TOKEN_BACKTICK    TokenType = "BACKTICK"    // `...` シェルの実行
+	TOKEN_INLINE_HTML TokenType = "INLINE_HTML" // <?php の外側
 )
 
+// 大文字小文字を区別しない
 var keywords = map[string]bool{
 	"if": true, "else": true, "while": true, "function": true,
 	"return": true, "echo": true, "class": true, "public": true,
+	"new": true, "fn": true, "print": true,
 }
 
 type Token struct {
 	Type      TokenType
-	Value     string
-	Lnum, Col int
+	Value     string       // 文字列はクォートの内側. ヒアドキュメントは字下げを除いたもの
+	Str       string       // 文字列リテラルの実行時の値. エスケープシーケンスを解釈したもの
+	Parts     []StringPart // 変数を埋め込んだ文字列のみ. 文字列と式に分けたもの
+	Lnum, Col int          // トークンの先頭の位置
 }
 
-func NewToken(typ TokenType, value string, pos scanner.Position) *Token {
-	return &Token{Type: typ, Value: value, Lnum: pos.Line, Col: pos.Column}
+// "Hello $name" の "Hello " と $name
+type StringPart struct {
+	Str  string // 文字列の部分の実行時の値
+	Expr string // 埋め込まれた式. $name, {$user->name} など. 文字列の部分は ""
 }
 
+// 実行時に値が変わる文字列か
+func (t *Token) isInterpolated() bool {
+	for _, p := range t.Parts {
+		if p.Expr != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func NewToken(typ TokenType, value string, lnum, col int) *Token {
+	tok := &Token{Type: typ, Value: value, Lnum: lnum, Col: col}
+	switch typ {
+	case TOKEN_STRING1:
+		tok.Str = unescapeSingle(value)
+	case TOKEN_STRING2:
+		tok.Str, tok.Parts = unescapeDouble(value, '"')
+	case TOKEN_HEREDOC:
+		tok.Str, tok.Parts = unescapeDouble(value, 0)
+	case TOKEN_BACKTICK:
+		tok.Str, tok.Parts = unescapeDouble(value, '`')
+	case TOKEN_NOWDOC:
+		tok.Str = value
+	}
+	return tok
+}
+
+// '...' は \' と \\ のみ
+func unescapeSingle(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '\'' || s[i+1] == '\\') {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+var doubleQuoteEscapes = map[byte]byte{
+	'n': '\n', 't': '\t', 'r': '\r', 'v': '\v', 'e': '\x1b', 'f': '\f',
+	'\\': '\\', '$': '$',
+}
+
+// "...", ヒアドキュメント, `...` のエスケープシーケンス.
+// quote は \" や \` のように自身をエスケープできるクォート. ヒアドキュメントは 0.
+// 解釈できないものはそのまま残す.
+// 変数が埋め込まれていれば文字列と式に分けたものも返す. 式は値の中にそのまま残す
+func unescapeDouble(s string, quote byte) (string, []StringPart) {
+	if !strings.ContainsAny(s, "\\$") {
+		return s, nil
+	}
+	var sb strings.Builder
+	var parts []StringPart
+	last := 0 // sb の中で parts に入れていない部分の始まり
+	for i := 0; i < len(s); i++ {
+		if end := interpolationEnd(s, i); end > 0 {
+			expr := s[i:end]
+			parts = append(parts, StringPart{Str: sb.String()[last:]}, StringPart{Expr: expr})
+			sb.WriteString(expr)
+			last = sb.Len()
+			i = end - 1
+			continue
+		}
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		c := s[i+1]
+		if v, ok := doubleQuoteEscapes[c]; ok {
+			sb.WriteByte(v)
+			i++
+		} else if c == quote {
+			sb.WriteByte(c)
+			i++
+		} else if '0' <= c && c <= '7' {
+			// \[0-7]{1,3}. 256 以上は下位 8 ビット
+			v, n := 0, 0
+			for ; n < 3 && i+1+n < len(s) && '0' <= s[i+1+n] && s[i+1+n] <= '7'; n++ {
+				v = v*8 + int(s[i+1+n]-'0')
+			}
+			sb.WriteByte(byte(v))
+			i += n
+		} else if c == 'x' && i+2 < len(s) && isHexDigit(s[i+2]) {
+			// \x[0-9A-Fa-f]{1,2}
+			v, n := 0, 0
+			for ; n < 2 && i+2+n < len(s) && isHexDigit(s[i+2+n]); n++ {
+				v = v*16 + hexValue(s[i+2+n])
+			}
+			sb.WriteByte(byte(v))
+			i += 1 + n
+		} else if c == 'u' && i+2 < len(s) && s[i+2] == '{' {
+			// \u{[0-9A-Fa-f]+}
+			end := strings.IndexByte(s[i+3:], '}')
+			v, ok := 0, end > 0
+			for j := 0; ok && j < end; j++ {
+				ok = isHexDigit(s[i+3+j]) && v <= utf8.MaxRune
+				v = v*16 + hexValue(s[i+3+j])
+			}
+			if !ok || v > utf8.MaxRune {
+				sb.WriteByte(s[i])
+				continue
+			}
+			sb.WriteString(encodeRune(rune(v)))
+			i += 3 + end
+		} else {
+			sb.WriteByte(s[i])
+		}
+	}
+	if parts != nil {
+		parts = append(parts, StringPart{Str: sb.String()[last:]})
+	}
+	return sb.String(), parts
+}
+
+// s[i:] が埋め込まれた式なら, その終わり. 式でなければ -1.
+// $name, $name[key], $name->prop, {$...}, ${...}
+func interpolationEnd(s string, i int) int {
+	if i+1 >= len(s) {
+		return -1
+	}
+	switch {
+	case s[i] == '$' && isIdentStart(s[i+1]):
+		j := identEnd(s, i+1)
+		if j < len(s) && s[j] == '[' {
+			if k := strings.IndexByte(s[j:], ']'); k > 0 {
+				j += k + 1
+			}
+		} else if strings.HasPrefix(s[j:], "->") && j+2 < len(s) && isIdentStart(s[j+2]) {
+			j = identEnd(s, j+2)
+		} else if strings.HasPrefix(s[j:], "?->") && j+3 < len(s) && isIdentStart(s[j+3]) {
+			j = identEnd(s, j+3)
+		}
+		return j
+	case s[i] == '{' && s[i+1] == '$', s[i] == '$' && s[i+1] == '{':
+		// 対応する } まで. 中の文字列の } では終わらない
+		depth := 0
+		for j := i; j < len(s); j++ {
+			switch s[j] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					return j + 1
+				}
+			case '\'', '"':
+				for q := s[j]; j+1 < len(s) && s[j+1] != q; j++ {
+					if s[j+1] == '\\' {
+						j++
+					}
+				}
+				j++
+			}
+		}
+		return len(s)
+	}
+	return -1
+}
+
+func identEnd(s string, i int) int {
+	for i < len(s) && isIdentChar(s[i]) {
+		i++
+	}
+	return i
+}
+
+// PHP はサロゲートも UTF-8 の形で書き出す
+func encodeRune(r rune) string {
+	if utf8.ValidRune(r) {
+		return string(r)
+	}
+	return string([]byte{0xe0 | byte(r>>12), 0x80 | byte(r>>6)&0x3f, 0x80 | byte(r)&0x3f})
+}
+
+func hexValue(c byte) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
+
+// PHP 7/8 の字句解析. <?php の外側は TOKEN_INLINE_HTML.
+// コメントと開始タグは読み飛ばし, <?= は echo, ?> は ; として返す
 type Lexer struct {
-	scanner scanner.Scanner
+	src       []byte
+	off       int
+	lnum, col int
+	inPHP     bool
 }
 
 func NewLexer(reader io.Reader) *Lexer {
-	var s scanner.Scanner
-	s.Init(reader)
-	s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats | scanner.ScanStrings | scanner.ScanChars | scanner.ScanRawStrings | scanner.ScanComments
-
-	s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanComments
+	src, _ := io.ReadAll(reader)
+	return &Lexer{src: src, lnum: 1, col: 1}
+}
 
-	return &Lexer{scanner: s}
+// 長いものから順に
+var symbols = []string{
+	"<<=", ">>=", "**=", "...", "<=>", "===", "!==", "??=", "?->",
+	"->", "=>", "::", "==", "!=", "<>", "<=", ">=", "&&", "||", "??",
+	"++", "--", "+=", "-=", "*=", "/=", ".=", "%=", "&=", "|=", "^=",
+	"<<", ">>", "**", "#[",
 }
 
 func (l *Lexer) NextToken() *Token {
 	for {
-		tok := l.scanner.Scan()
-		if tok == scanner.EOF {
-			return NewToken(TOKEN_EOF, "", l.scanner.Pos())
+		if l.off >= len(l.src) {
+			return NewToken(TOKEN_EOF, "", l.lnum, l.col)
+		}
+		if !l.inPHP {
+			if tok := l.scanInlineHTML(); tok != nil {
+				return tok
+			}
+			continue
 		}
 
-		// コメントを無視
-		if tok == scanner.Comment {
+		lnum, col := l.lnum, l.col
+		c := l.src[l.off]
+		switch {
+		case isSpace(c):
+			l.next()
 			continue
+		case l.hasPrefix("?>"):
+			// 終了タグの直後の改行は出力されない
+			l.advance(2)
+			if l.hasPrefix("\r\n") {
+				l.advance(2)
+			} else if l.hasPrefix("\n") {
+				l.advance(1)
+			}
+			l.inPHP = false
+			return NewToken(TOKEN_SYMBOL, ";", lnum, col)
+		case c == '#' && !l.hasPrefix("#["), l.hasPrefix("//"):
+			l.skipLineComment()
+			continue
+		case l.hasPrefix("/*"):
+			l.advance(2)
+			for l.off < len(l.src) && !l.hasPrefix("*/") {
+				l.next()
+			}
+			l.advance(2)
+			continue
+		case c == '\'':
+			l.next()
+			return NewToken(TOKEN_STRING1, l.scanQuoted('\''), lnum, col)
+		case c == '"':
+			l.next()
+			return NewToken(TOKEN_STRING2, l.scanQuoted('"'), lnum, col)
+		case c == '`':
+			l.next()
+			return NewToken(TOKEN_BACKTICK, l.scanQuoted('`'), lnum, col)
+		case l.hasPrefix("<<<"):
+			if tok := l.scanHeredoc(); tok != nil {
+				return tok
+			}
+		case c == '$' && l.off+1 < len(l.src) && isIdentStart(l.src[l.off+1]):
+			l.next()
+			return NewToken(TOKEN_VARIABLE, "$"+l.scanIdent(), lnum, col)
+		case isIdentStart(c):
+			text := l.scanIdent()
+			if keywords[strings.ToLower(text)] {
+				return NewToken(TOKEN_KEYWORD, text, lnum, col)
+			}
+			return NewToken(TOKEN_IDENTIFIER, text, lnum, col)
+		case isDigit(c) || c == '.' && l.off+1 < len(l.src) && isDigit(l.src[l.off+1]):
+			return NewToken(TOKEN_NUMBER, l.scanNumber(), lnum, col)
 		}
 
-		if tok == '"' || tok == '\'' {
-			runes := make([]rune, 0)
-			escape := false
-			for {
-				r := l.scanner.Next()
-				if r == tok && !escape || r == scanner.EOF {
-					break
-				}
+		for _, s := range symbols {
+			if l.hasPrefix(s) {
+				l.advance(len(s))
+				return NewToken(TOKEN_SYMBOL, s, lnum, col)
+			}
+		}
+		l.next()
+		return NewToken(TOKEN_SYMBOL, string(c), lnum, col)
+	}
+}
 
-				if r == '\\' {
-					escape = !escape
-				} else {
-					escape = false
-				}
+// 開始タグの前までの HTML を読む.
+// 開始タグを読んだ場合は <?= なら echo, それ以外は nil
+func (l *Lexer) scanInlineHTML() *Token {
+	lnum, col := l.lnum, l.col
+	start := l.off
+	for l.off < len(l.src) {
+		if n := l.openTag(); n > 0 {
+			if l.off > start {
+				return NewToken(TOKEN_INLINE_HTML, string(l.src[start:l.off]), lnum, col)
+			}
+			echo := n == len("<?=")
+			l.advance(n)
+			l.inPHP = true
+			if echo {
+				return NewToken(TOKEN_KEYWORD, "echo", lnum, col)
+			}
+			return nil
+		}
+		l.next()
+	}
+	return NewToken(TOKEN_INLINE_HTML, string(l.src[start:]), lnum, col)
+}
+
+// <?php, <?=, 短い開始タグ <? の長さ. 開始タグでなければ 0.
+// <?xml などは HTML のまま
+func (l *Lexer) openTag() int {
+	switch {
+	case !l.hasPrefix("<?"):
+		return 0
+	case l.hasPrefix("<?="):
+		return 3
+	case l.hasPrefixFold("<?php") && (l.off+5 == len(l.src) || isSpace(l.src[l.off+5])):
+		return 5
+	case l.off+2 == len(l.src) || isSpace(l.src[l.off+2]):
+		return 2
+	}
+	return 0
+}
 
-				runes = append(runes, r)
+// 行末か ?> まで
+func (l *Lexer) skipLineComment() {
+	for l.off < len(l.src) && l.src[l.off] != '\n' && !l.hasPrefix("?>") {
+		l.next()
+	}
+}
+
+// 開始のクォートの直後から読み, クォートの内側をそのまま返す
+func (l *Lexer) scanQuoted(quote byte) string {
+	start := l.off
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case c == quote:
+			s := string(l.src[start:l.off])
+			l.next()
+			return s
+		case c == '\\':
+			l.next()
+			if l.off < len(l.src) {
+				l.next()
 			}
+		case quote != '\'' && (l.hasPrefix("{$") || l.hasPrefix("${")):
+			l.skipInterpolation()
+		default:
+			l.next()
+		}
+	}
+	return string(l.src[start:])
+}
+
+// "{$a["key"]}" などの埋め込まれた式. 中の文字列のクォートで終わらないように読み飛ばす
+func (l *Lexer) skipInterpolation() {
+	depth := 0
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		l.next()
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return
+			}
+		case '\'', '"':
+			l.scanQuoted(c)
+		}
+	}
+}
+
+// <<<EOT, <<<"EOT", <<<'EOT'. ヒアドキュメントでなければ nil
+func (l *Lexer) scanHeredoc() *Token {
+	lnum, col := l.lnum, l.col
+	p := l.off + 3
+	for p < len(l.src) && (l.src[p] == ' ' || l.src[p] == '\t') {
+		p++
+	}
+	typ := TOKEN_HEREDOC
+	quote := byte(0)
+	if p < len(l.src) && (l.src[p] == '\'' || l.src[p] == '"') {
+		quote = l.src[p]
+		if quote == '\'' {
+			typ = TOKEN_NOWDOC
+		}
+		p++
+	}
+	labelStart := p
+	for p < len(l.src) && isIdentChar(l.src[p]) {
+		p++
+	}
+	label := l.src[labelStart:p]
+	if len(label) == 0 || !isIdentStart(label[0]) {
+		return nil
+	}
+	if quote != 0 {
+		if p >= len(l.src) || l.src[p] != quote {
+			return nil
+		}
+		p++
+	}
+	if p < len(l.src) && l.src[p] == '\r' {
+		p++
+	}
+	if p >= len(l.src) || l.src[p] != '\n' {
+		return nil
+	}
+	p++
 
-			var t TokenType
-			if tok == '"' {
-				t = TOKEN_STRING2
-			} else {
-				t = TOKEN_STRING1
+	// 終了の識別子の行を探す. PHP 7.3 以降は字下げしてよい
+	bodyStart := p
+	for lineStart := p; lineStart <= len(l.src); {
+		q := lineStart
+		for q < len(l.src) && (l.src[q] == ' ' || l.src[q] == '\t') {
+			q++
+		}
+		if bytes.HasPrefix(l.src[q:], label) && (q+len(label) == len(l.src) || !isIdentChar(l.src[q+len(label)])) {
+			indent := q - lineStart
+			body := ""
+			if lineStart > bodyStart {
+				body = dedent(string(l.src[bodyStart:lineStart-1]), indent)
+			}
+			for l.off < q+len(label) {
+				l.next()
 			}
+			return NewToken(typ, strings.TrimSuffix(body, "\r"), lnum, col)
+		}
 
-			return NewToken(t, string(runes), l.scanner.Pos())
+		n := bytes.IndexByte(l.src[lineStart:], '\n')
+		if n < 0 {
+			break
 		}
+		lineStart += n + 1
+	}
 
-		text := l.scanner.TokenText()
-		if keywords[text] {
-			return NewToken(TOKEN_KEYWORD, text, l.scanner.Pos())
-		} else if tok == scanner.Int || tok == scanner.Float {
-			return NewToken(TOKEN_NUMBER, text, l.scanner.Pos())
-		} else if tok == scanner.Ident {
-			return NewToken(TOKEN_IDENTIFIER, text, l.scanner.Pos())
-		} else {
-			return NewToken(TOKEN_SYMBOL, text, l.scanner.Pos())
+	// 終わらないヒアドキュメント
+	for l.off < len(l.src) {
+		l.next()
+	}
+	return NewToken(typ, dedent(string(l.src[bodyStart:]), 0), lnum, col)
+}
+
+// 各行の先頭から n 文字の空白を除く
+func dedent(s string, n int) string {
+	if n == 0 {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		m := 0
+		for m < n && m < len(line) && (line[m] == ' ' || line[m] == '\t') {
+			m++
 		}
+		lines[i] = line[m:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (l *Lexer) scanIdent() string {
+	start := l.off
+	for l.off < len(l.src) && isIdentChar(l.src[l.off]) {
+		l.next()
+	}
+	return string(l.src[start:l.off])
+}
+
+// 10 進, 0x, 0b, 0o, 小数, 指数. _ 区切りを含む
+func (l *Lexer) scanNumber() string {
+	start := l.off
+	if l.hasPrefixFold("0x") || l.hasPrefixFold("0b") || l.hasPrefixFold("0o") {
+		l.advance(2)
+		for l.off < len(l.src) && (isHexDigit(l.src[l.off]) || l.src[l.off] == '_') {
+			l.next()
+		}
+		return string(l.src[start:l.off])
+	}
+	for l.off < len(l.src) && (isDigit(l.src[l.off]) || l.src[l.off] == '_' || l.src[l.off] == '.') {
+		l.next()
+	}
+	if l.off < len(l.src) && (l.src[l.off] == 'e' || l.src[l.off] == 'E') {
+		p := l.off + 1
+		if p < len(l.src) && (l.src[p] == '+' || l.src[p] == '-') {
+			p++
+		}
+		if p < len(l.src) && isDigit(l.src[p]) {
+			for l.off < p {
+				l.next()
+			}
+			for l.off < len(l.src) && (isDigit(l.src[l.off]) || l.src[l.off] == '_') {
+				l.next()
+			}
+		}
+	}
+	return string(l.src[start:l.off])
+}
+
+// 1 バイト進める. 列は文字単位で数える
+func (l *Lexer) next() {
+	c := l.src[l.off]
+	l.off++
+	if c == '\n' {
+		l.lnum++
+		l.col = 1
+	} else if c&0xc0 != 0x80 {
+		l.col++
 	}
 }
+
+func (l *Lexer) advance(n int) {
+	for i := 0; i < n && l.off < len(l.src); i++ {
+		l.next()
+	}
+}
+
+func (l *Lexer) hasPrefix(s string) bool {
+	return bytes.HasPrefix(l.src[l.off:], []byte(s))
+}
+
+func (l *Lexer) hasPrefixFold(s string) bool {
+	return len(l.src)-l.off >= len(s) && strings.EqualFold(string(l.src[l.off:l.off+len(s)]), s)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// PHP の識別子は 0x80 以上のバイトも使える
+func isIdentStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -8,7 +8,47 @@ import (
 	"strings"
 )
 
-func ParsePHPDir(linter *com.Linter, dirname string, entriesDict map[string]map[string]*com.PoEntry) error {
+func ParsePHPDir(linter *com.Linter, config *Config, dirname string, entriesDict map[string]map[string]*com.PoEntry) error {
+	files, err := readPHPDir(linter, dirname)
+	if err != nil {
+		return err
+	}
+	config = findWrappers(linter, config, files)
+
+	unresolved := 0
+	for _, f := range files {
+		calls, n := findCalls(linter, config, f.filename, f.tokens)
+		unresolved += n
+		for _, c := range calls {
+			checkCall(linter, config, f.filename, c, entriesDict)
+		}
+	}
+	linter.Dprintf("%s: %d calls could not be resolved\n", dirname, unresolved)
+	return nil
+}
+
+// 字句解析済みの PHP ファイル
+type phpFile struct {
+	filename string
+	tokens   []*Token
+}
+
+// ラッパー関数を探すため, dirname 配下をすべて読んでおく
+func readPHPDir(linter *com.Linter, dirname string) ([]*phpFile, error) {
+	files := make([]*phpFile, 0)
+	err := walkPHPDir(linter, dirname, func(filename string) error {
+		tokens, err := readPHPFile(linter, filename)
+		if err != nil {
+			return err
+		}
+		files = append(files, &phpFile{filename: filename, tokens: tokens})
+		return nil
+	})
+	return files, err
+}
+
+// dirname 配下の *.php ファイルそれぞれについて fn を呼ぶ
+func walkPHPDir(linter *com.Linter, dirname string, fn func(filename string) error) error {
 
 	// dirname 配下のファイル/ディレクトリを取得
 	files, err := filepath.Glob(dirname + "/*")
@@ -20,7 +60,7 @@ func ParsePHPDir(linter *com.Linter, dirname string, entriesDict map[string]map[
 	for _, file := range files {
 		// ディレクトリなら再起に
 		if fi, err := os.Stat(file); err == nil && fi.IsDir() {
-			err = ParsePHPDir(linter, file, entriesDict)
+			err = walkPHPDir(linter, file, fn)
 			if err != nil {
 				linter.Logger.Fatal(err)
 				return err
@@ -28,9 +68,9 @@ func ParsePHPDir(linter *com.Linter, dirname string, entriesDict map[string]map[
 			continue
 		}
 
-		// *.php ファイルなら解析する
-		if strings.HasSuffix(file, ".php") {
-			err = parsePHPFile(linter, file, entriesDict)
+		// *.php ファイルと CakePHP 2 のビュー *.ctp なら解析する
+		if strings.HasSuffix(file, ".php") || strings.HasSuffix(file, ".ctp") {
+			err = fn(file)
 			if err != nil {
 				linter.Logger.Fatal(err)
 				return err
@@ -40,19 +80,64 @@ func ParsePHPDir(linter *com.Linter, dirname string, entriesDict map[string]map[
 	return nil
 }
 
-func parsePHPFile(linter *com.Linter, filename string, entriesDict map[string]map[string]*com.PoEntry) error {
+// 翻訳関数の呼び出し
+type call struct {
+	tok     *Token // 関数名
+	index   int    // tok の tokens 中の位置
+	fn      *Function
+	domain  *Token // 文字列リテラル. ドメインの引数が無い関数は nil
+	context *Token
+	msgid   *Token
+	plural  *Token
+	args    *boundArgs
+}
+
+func (c *call) name() string {
+	return c.tok.Value
+}
+
+// 実行時のドメイン
+func (c *call) domainValue(config *Config) string {
+	if c.domain == nil {
+		if c.fn.FixedDomain != "" {
+			return c.fn.FixedDomain
+		}
+		return config.DefaultDomain
+	}
+	return stringValue(c.domain)
+}
+
+// msgctxt. 無ければ ""
+func (c *call) contextValue() string {
+	if c.context == nil {
+		return c.fn.FixedContext
+	}
+	return stringValue(c.context)
+}
+
+// エラーメッセージ用. __d(domain,msgid) など
+func (c *call) label() string {
+	values := make([]string, 0, 3)
+	for _, t := range []*Token{c.domain, c.context, c.msgid} {
+		if t != nil {
+			values = append(values, t.Value)
+		}
+	}
+	return c.name() + "(" + strings.Join(values, ",") + ")"
+}
+
+func readPHPFile(linter *com.Linter, filename string) ([]*Token, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		linter.Logger.Fatal(err)
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
 	lexer := NewLexer(file)
-	lexer.scanner.Position.Filename = filename
 	nofile := false
 	if nofile {
-		sss := `
+		sss := `<?php
         $data = [
             'title_icon' => '',
             //'content_id' => '',
@@ -63,90 +148,251 @@ func parsePHPFile(linter *com.Linter, filename string, entriesDict map[string]ma
 
 	tokens := getTokens(lexer)
 	linter.Dprintf("start parsePHPFile(%s): %d tokens\n", filename, len(tokens))
+	return tokens, nil
+}
+
+// tokens 中の翻訳関数の呼び出しを探す.
+// 引数が文字列リテラルでないなど解析できない呼び出しは報告して除く.
+// 除いた呼び出しの数も返す
+func findCalls(linter *com.Linter, config *Config, filename string, tokens []*Token) ([]*call, int) {
+	calls := make([]*call, 0)
+	consts := newConstants(tokens)
+	unresolved := 0
 	for i := 0; i < len(tokens); i++ {
 		tok := tokens[i]
-		if tok.is(TOKEN_IDENTIFIER, "__d") {
-			if i+6 >= len(tokens) {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Invalid __d function")
-				continue
-			}
+		if !tok.isType(TOKEN_IDENTIFIER) {
+			continue
+		}
+		fn, ok := config.lookup(tokens, i, consts.class[i])
+		if !ok {
+			continue
+		}
 
-			if !tokens[i+1].is(TOKEN_SYMBOL, "(") {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Invalid __d function: missing '('")
+		if i+1 >= len(tokens) || !tokens[i+1].is(TOKEN_SYMBOL, "(") {
+			linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Invalid "+tok.Value+" function: missing '('")
+			continue
+		}
+		args, ok := parseArgs(tokens[i+2:])
+		if !ok {
+			linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Invalid "+tok.Value+" function: missing ')'")
+			continue
+		}
+		bound, err := bindArgs(fn, args)
+		if err != nil {
+			linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, fmt.Sprintf("Invalid %s function: %v", tok.Value, err))
+			continue
+		}
+		if bound == nil {
+			linter.Dprintf("%s:%d: unresolved call of %s(): arguments are unpacked or named\n", filename, tok.Lnum, tok.Value)
+			unresolved++
+			continue
+		}
+		if !fn.filled(bound) {
+			linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, fmt.Sprintf("Invalid %s function: %d arguments required. actual=%d", tok.Value, fn.required(), len(args)))
+			continue
+		}
+
+		c := &call{tok: tok, index: i, fn: fn, args: bound}
+		ok = true
+		for _, v := range []struct {
+			pos int
+			ptr **Token
+		}{
+			{fn.Domain, &c.domain},
+			{fn.Context, &c.context},
+			{fn.MsgID, &c.msgid},
+			{fn.Plural, &c.plural},
+		} {
+			if v.pos < 0 {
 				continue
 			}
-			if tokens[i+2].isType(TOKEN_STRING2) {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, "1st argument of __d() should be a single quoted string not a double quoted string.")
-			} else if tokens[i+2].is(TOKEN_SYMBOL, "$") {
-				// 解析不可能故逃げる
-				continue
-			} else if !tokens[i+2].isType(TOKEN_STRING1) {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, "1st argument of __d() should be a single quoted string: "+tokens[i+2].Value)
-				continue
+			*v.ptr = literalArg(linter, filename, c, v.pos, consts)
+			if *v.ptr == nil {
+				ok = false
+				break
 			}
+		}
+		if ok {
+			calls = append(calls, c)
+		} else {
+			linter.Dprintf("%s:%d: unresolved call of %s()\n", filename, tok.Lnum, tok.Value)
+			unresolved++
+		}
+	}
+	return calls, unresolved
+}
 
-			if !tokens[i+3].is(TOKEN_SYMBOL, ",") {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Invalid __d function: missing ','")
-				continue
-			}
+// pos 番目の引数が文字列リテラルならそのトークン.
+// 値が文字列に決まる変数や定数なら, その値のトークン
+func literalArg(linter *com.Linter, filename string, c *call, pos int, consts *constants) *Token {
+	arg := c.args.value(pos)
+	if len(arg) == 1 && (arg[0].isType(TOKEN_STRING1) || arg[0].isType(TOKEN_NOWDOC)) {
+		return arg[0]
+	}
+	if t, ok := consts.resolve(arg, c.index); ok {
+		return t
+	}
 
-			if tokens[i+4].isType(TOKEN_STRING2) {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, "2nd argument of __d() should be a single quoted string not a double quoted string.")
-			} else if tokens[i+4].is(TOKEN_SYMBOL, "$") {
-				// 解析不可能故逃げる
-				continue
-			} else if !tokens[i+4].isType(TOKEN_STRING1) {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, "2nd argument of __d() should be a single quoted string: "+tokens[i+4].Value)
-				continue
+	tok := c.tok
+	if len(arg) == 1 && arg[0].isInterpolated() {
+		exprs := make([]string, 0)
+		for _, p := range arg[0].Parts {
+			if p.Expr != "" {
+				exprs = append(exprs, p.Expr)
 			}
-
-			if !tokens[i+5].is(TOKEN_SYMBOL, ",") && !tokens[i+5].is(TOKEN_SYMBOL, ")") {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Invalid __d function: missing ',' or ')'")
-				continue
+		}
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, fmt.Sprintf("%s argument of %s() contains variable interpolation: %s", ordinal(pos+1), c.name(), strings.Join(exprs, ", ")))
+		return nil
+	}
+	if len(arg) == 1 && (arg[0].isType(TOKEN_STRING2) || arg[0].isType(TOKEN_HEREDOC)) {
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, fmt.Sprintf("%s argument of %s() should be a single quoted string not a double quoted string.", ordinal(pos+1), c.name()))
+		return arg[0]
+	}
+	values := make([]string, 0, len(arg))
+	concat := false
+	depth := 0
+	for _, t := range arg {
+		values = append(values, t.Value)
+		if t.isType(TOKEN_SYMBOL) {
+			switch t.Value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			case ".":
+				concat = concat || depth == 0
 			}
+		}
+	}
+	if concat {
+		// 文字列リテラル同士の連結は getTokens で 1 つになっている
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, fmt.Sprintf("%s argument of %s() is not extractable: concatenated with a non-literal value: %s", ordinal(pos+1), c.name(), strings.Join(values, " ")))
+		return nil
+	}
+	if len(arg) > 0 && (arg[0].isType(TOKEN_VARIABLE) || arg[0].is(TOKEN_SYMBOL, "$")) {
+		// 解析不可能故逃げる
+		return nil
+	}
+	linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, fmt.Sprintf("%s argument of %s() should be a single quoted string: %s", ordinal(pos+1), c.name(), strings.Join(values, " ")))
+	return nil
+}
 
-			entries, ok := entriesDict[tokens[i+2].Value]
-			if !ok {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Unknown domain: "+tokens[i+2].Value+", msgid="+tokens[i+4].Value)
-				continue
-			}
+// 1st, 2nd, ...
+func ordinal(n int) string {
+	switch {
+	case n%100/10 == 1:
+		return fmt.Sprintf("%dth", n)
+	case n%10 == 1:
+		return fmt.Sprintf("%dst", n)
+	case n%10 == 2:
+		return fmt.Sprintf("%dnd", n)
+	case n%10 == 3:
+		return fmt.Sprintf("%drd", n)
+	}
+	return fmt.Sprintf("%dth", n)
+}
 
-			entry, ok := entries[tokens[i+4].Value]
-			if !ok {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Unknown msgid: __d("+tokens[i+2].Value+","+tokens[i+4].Value+")")
-				continue
+// '(' の直後からの tokens を対応する ')' まで引数ごとに分ける.
+// 末尾の ',' は無視する. ')' が無ければ false
+func splitArgs(tokens []*Token) ([][]*Token, bool) {
+	args := make([][]*Token, 0)
+	arg := make([]*Token, 0)
+	depth := 0
+	for _, t := range tokens {
+		if t.isType(TOKEN_SYMBOL) {
+			switch t.Value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
 			}
-
-			var placeholder int
-			for placeholder = 9; placeholder >= 0; placeholder-- {
-				if strings.Contains(entry.MsgStr, fmt.Sprintf("{%d}", placeholder)) {
-					break
+			if depth < 0 {
+				if len(arg) > 0 {
+					args = append(args, arg)
 				}
+				return args, true
 			}
-
-			var argnum int
-			if tokens[i+5].is(TOKEN_SYMBOL, ")") {
-				argnum = 0
-			} else if !tokens[i+5].is(TOKEN_SYMBOL, ",") {
-				// 文法エラー...?
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Invalid __d function: missing ','")
+			if depth == 0 && t.Value == "," {
+				args = append(args, arg)
+				arg = make([]*Token, 0)
 				continue
 			}
-			if tokens[i+6].is(TOKEN_SYMBOL, "[") {
-				argnum = getArgNum(tokens[i+7:], "]")
-			} else {
-				argnum = getArgNum(tokens[i+6:], ")")
-			}
+		}
+		arg = append(arg, t)
+	}
+	return args, false
+}
 
-			// fmt.Printf("placeholder=%d, argnum=%d, 5=%v 6=%v\n", placeholder, argnum, tokens[i+5].Value, tokens[i+6].Value)
-			if argnum < placeholder+1 {
-				linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, fmt.Sprintf("Invalid __d function: missing %d-th argument for {%d}. actual=%d", placeholder+1, placeholder, argnum))
-			}
+// 呼び出しをカタログと照合する
+func checkCall(linter *com.Linter, config *Config, filename string, c *call, entriesDict map[string]map[string]*com.PoEntry) {
+	tok := c.tok
+	domain := c.domainValue(config)
+	entries, ok := entriesDict[domain]
+	if !ok {
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Unknown domain: "+domain+", msgid="+c.msgid.Value)
+		return
+	}
+
+	// *.po のエスケープは解釈済みなので実行時の値で比較する
+	entry, ok := entries[com.PoKey(c.contextValue(), stringValue(c.msgid))]
+	if !ok {
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, "Unknown msgid: "+c.label())
+		return
+	}
+	entry.Called++
+
+	if entry.Obsolete {
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, fmt.Sprintf("Obsolete msgid: %s [%s:%d]", c.label(), entry.Filename, entry.Pos.Line))
+	}
+	if entry.IsFuzzy() {
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, fmt.Sprintf("msgid is fuzzy and treated as untranslated: %s [%s:%d]", c.label(), entry.Filename, entry.Pos.Line))
+	}
+
+	if c.plural != nil {
+		if !entry.IsPlural() {
+			linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, fmt.Sprintf("msgid has no plural forms: %s [%s:%d]", c.label(), entry.Filename, entry.Pos.Line))
+		} else if plural := stringValue(c.plural); plural != entry.MsgIDPlural {
+			linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, fmt.Sprintf("msgid_plural mismatch: %s: %q != %q [%s:%d]", c.label(), plural, entry.MsgIDPlural, entry.Filename, entry.Pos.Line))
 		}
+	} else if entry.IsPlural() {
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelWarning, fmt.Sprintf("msgid has plural forms; use a plural function: %s [%s:%d]", c.label(), entry.Filename, entry.Pos.Line))
 	}
 
-	// Error handler
-	return nil
+	placeholder := -1
+	for _, s := range entry.MsgStrForms() {
+		placeholder = max(placeholder, maxPlaceholder(s))
+	}
+
+	// 置き換える値は可変長引数または配列で渡す
+	if c.fn.Args < 0 {
+		return
+	}
+	rest := c.args.rest
+	for _, a := range rest {
+		if a.spread {
+			// 値の数が分からない
+			return
+		}
+	}
+	argnum := len(rest)
+	if len(rest) == 1 && rest[0].name == "" && len(rest[0].tokens) > 0 && rest[0].tokens[0].is(TOKEN_SYMBOL, "[") {
+		argnum = getArgNum(rest[0].tokens[1:], "]")
+	}
+
+	if argnum < placeholder+1 {
+		linter.Reporter.ReportError(filename, tok.Lnum, tok.Col, com.LevelError, fmt.Sprintf("Invalid %s function: missing %d-th argument for {%d}. actual=%d", c.name(), placeholder+1, placeholder, argnum))
+	}
+}
+
+// s 中の {N} の最大の N. 無ければ -1
+func maxPlaceholder(s string) int {
+	var placeholder int
+	for placeholder = 9; placeholder >= 0; placeholder-- {
+		if strings.Contains(s, fmt.Sprintf("{%d}", placeholder)) {
+			break
+		}
+	}
+	return placeholder
 }
 
 func getArgNum(tokens []*Token, end string) int {
@@ -217,19 +463,35 @@ func getTokens(lexer *Lexer) []*Token {
 	return ret
 }
 
+// 文字列リテラルの実行時の値
+func stringValue(t *Token) string {
+	return t.Str
+}
+
 /**
  * 破壊的
  */
 func (t *Token) concat(s *Token) *Token {
+	if t.Parts != nil || s.Parts != nil {
+		t.Parts = append(t.stringParts(), s.stringParts()...)
+	}
 	t.Value += s.Value
+	t.Str += s.Str
 	if t.Type != s.Type {
 		t.Type = TOKEN_STRING2
 	}
 	return t
 }
 
+func (t *Token) stringParts() []StringPart {
+	if t.Parts != nil {
+		return t.Parts
+	}
+	return []StringPart{{Str: t.Str}}
+}
+
 func (t *Token) isString() bool {
-	return t.isType(TOKEN_STRING1) || t.isType(TOKEN_STRING2)
+	return t.isType(TOKEN_STRING1) || t.isType(TOKEN_STRING2) || t.isType(TOKEN_HEREDOC) || t.isType(TOKEN_NOWDOC)
 }
 
 func (t *Token) isType(tokenType TokenType) bool {
@@ -1,6 +1,14 @@
 package php
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"polinco/com"
+	"polinco/po"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -21,7 +29,7 @@ func TestGetArgNum(t *testing.T) {
 		{"__d('cake', 'a', __d('boo', 'ruuuu', 3,))", 6, ")", 1},
 		{"__d('access_counters', 'The number of ', [ __d('access_counters', 'Starting Value'), 0, AccessCounterConst::MAX_COUNT_START, ],", 7, "]", 3},
 	} {
-		lexer := NewLexer(strings.NewReader(s.input))
+		lexer := NewLexer(strings.NewReader("<?php " + s.input))
 		tokens := getTokens(lexer)
 
 		v := getArgNum(tokens[s.idx:], s.end)
@@ -80,15 +88,14 @@ func TestGetTokens(t *testing.T) {
 			{TOKEN_NUMBER, "0"},
 			{TOKEN_SYMBOL, ","},
 			{TOKEN_IDENTIFIER, "AccessCounterConst"},
-			{TOKEN_SYMBOL, ":"},
-			{TOKEN_SYMBOL, ":"},
+			{TOKEN_SYMBOL, "::"},
 			{TOKEN_IDENTIFIER, "MAX_COUNT_START"},
 			{TOKEN_SYMBOL, ","},
 			{TOKEN_SYMBOL, "]"},
 			{TOKEN_SYMBOL, ","},
 		}},
 	} {
-		lexer := NewLexer(strings.NewReader(s.input))
+		lexer := NewLexer(strings.NewReader("<?php " + s.input))
 		tokens := getTokens(lexer)
 
 		if len(tokens) != len(s.expect) {
@@ -104,3 +111,741 @@ func TestGetTokens(t *testing.T) {
 		}
 	}
 }
+
+func TestStringValue(t *testing.T) {
+	for _, s := range []struct {
+		input  string
+		expect string
+	}{
+		{`'abc'`, "abc"},
+		{`'It\'s'`, "It's"},
+		{`'a\\b\n'`, `a\b\n`},
+		{`"a\nb\t\"c\" \$x"`, "a\nb\t\"c\" $x"},
+		{`"\'"`, `\'`},
+		{`'a\'b' . "\n"`, "a'b\n"},
+		{`"\x41\101\0\e\q\\"`, "AA\x00\x1b\\q\\"},
+		{`"\xZ\400"`, "\\xZ\x00"},
+		{`"\u{48}\u{1F600}\u{} \u0041"`, "H\U0001F600\\u{} \\u0041"},
+		{`"\u{D800}"`, "\xed\xa0\x80"},
+		{"<<<EOT\n  \\\"a\\tb\n  EOT", "\\\"a\tb"},
+		{"<<<'EOT'\n\\n\nEOT", "\\n"},
+	} {
+		lexer := NewLexer(strings.NewReader("<?php " + s.input))
+		tokens := getTokens(lexer)
+		if len(tokens) != 1 || !tokens[0].isString() {
+			t.Errorf("\ninput =%v\nactual=%v\n", s.input, tokens)
+			continue
+		}
+
+		v := stringValue(tokens[0])
+		if v != s.expect {
+			t.Errorf("\ninput =%v\nexpect=%q\nactual=%q\n", s.input, s.expect, v)
+		}
+	}
+}
+
+func newTestLinter() *com.Linter {
+	return &com.Linter{Reporter: com.NewReporter("plain"), Logger: log.New(io.Discard, "", 0)}
+}
+
+func TestCatalogAdd(t *testing.T) {
+	input := `<?php
+echo __d('blog', 'Hello {0}', $name);
+echo __d('blog', 'It\'s');
+echo __d('cake', 'Hello {0}', $x);
+echo __d('blog', 'Hello {0}', $y);
+echo __d($domain, 'skip');
+echo __('Top');
+echo __xn('menu', 'One file', '{0} files', $n, $n);
+echo __dx('blog', 'menu', 'Top');
+`
+	linter := newTestLinter()
+	config := NewConfig()
+	tokens := getTokens(NewLexer(strings.NewReader(input)))
+	catalog := NewCatalog("/plugin")
+	calls, _ := findCalls(linter, config, "/plugin/src/a.php", tokens)
+	catalog.addCalls(config, "/plugin/src/a.php", calls)
+
+	if len(catalog.Domains) != 3 {
+		t.Fatalf("domains=%v", catalog.Domains)
+	}
+	blog := catalog.Domains["blog"]
+	if len(blog) != 3 || blog[0].MsgID != "Hello {0}" || blog[1].MsgID != "It's" || blog[2].Key() != "menu\x04Top" {
+		t.Fatalf("blog=%v", blog)
+	}
+	if strings.Join(blog[0].References, " ") != "src/a.php:2 src/a.php:5" {
+		t.Errorf("references=%v", blog[0].References)
+	}
+	if !blog[0].HasFlag("php-format") || blog[1].HasFlag("php-format") {
+		t.Errorf("flags=%v, %v", blog[0].Flags, blog[1].Flags)
+	}
+
+	def := catalog.Domains["default"]
+	if len(def) != 2 || def[0].MsgID != "Top" || def[1].Context != "menu" || def[1].MsgIDPlural != "{0} files" || !def[1].IsPlural() || !def[1].HasFlag("php-format") {
+		t.Errorf("default=%v", def)
+	}
+}
+
+func TestExtractFormat(t *testing.T) {
+	files := []*phpFile{
+		{filename: "/plugin/src/Controller/BlogsController.php", tokens: getTokens(NewLexer(strings.NewReader(`<?php
+echo __d('blog', 'Hello {0}', $name);
+echo __dn('blog', 'One file', '{0} files', $n, $n);
+echo __dx('blog', 'menu', 'Top');
+`)))},
+		{filename: "/plugin/templates/Blogs/index.php", tokens: getTokens(NewLexer(strings.NewReader(`<?php
+echo __d('blog', 'Hello {0}', $user);
+echo __d('blog', 'It\'s "ok"');
+`)))},
+	}
+
+	// extract と同じく output (plugin/resources/locales) の 2 つ上を基準にする
+	linter := newTestLinter()
+	config := findWrappers(linter, NewConfig(), files)
+	catalog := NewCatalog(filepath.Dir(filepath.Dir("/plugin/resources/locales")))
+	for _, f := range files {
+		calls, _ := findCalls(linter, config, f.filename, f.tokens)
+		catalog.addCalls(config, f.filename, calls)
+	}
+
+	header := po.NewHeader([]po.HeaderField{
+		{Key: "Project-Id-Version", Value: "PACKAGE VERSION"},
+		{Key: "Content-Type", Value: "text/plain; charset=UTF-8"},
+	})
+	header.Entry.Flags = []string{"fuzzy"}
+	var buf bytes.Buffer
+	if err := po.Format(&buf, &po.File{Header: header, Entries: catalog.Domains["blog"]}, po.FormatOption{Width: 79}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expect := `#, fuzzy
+msgid ""
+msgstr ""
+"Project-Id-Version: PACKAGE VERSION\n"
+"Content-Type: text/plain; charset=UTF-8\n"
+
+#: src/Controller/BlogsController.php:2 templates/Blogs/index.php:2
+#, php-format
+msgid "Hello {0}"
+msgstr ""
+
+#: src/Controller/BlogsController.php:3
+#, php-format
+msgid "One file"
+msgid_plural "{0} files"
+msgstr[0] ""
+msgstr[1] ""
+
+#: src/Controller/BlogsController.php:4
+msgctxt "menu"
+msgid "Top"
+msgstr ""
+
+#: templates/Blogs/index.php:3
+msgid "It's \"ok\""
+msgstr ""
+`
+	if buf.String() != expect {
+		t.Errorf("\nexpect=%s\nactual=%s", expect, buf.String())
+	}
+}
+
+func TestCheckCall(t *testing.T) {
+	entriesDict := map[string]map[string]*com.PoEntry{
+		"default": {
+			"Hello {0}":   {MsgID: "Hello {0}", MsgStr: "こんにちは {0}"},
+			"menu\x04Top": {Context: "menu", MsgID: "Top", MsgStr: "トップ"},
+			"{0} file":    {MsgID: "{0} file", MsgIDPlural: "{0} files", MsgStrs: []string{"{0} ファイル"}},
+			"Old":         {MsgID: "Old", MsgStr: "古い", Obsolete: true},
+			"Draft":       {MsgID: "Draft", MsgStr: "下書き", Flags: []string{"fuzzy"}},
+			"It's \"ok\"": {MsgID: "It's \"ok\"", MsgStr: "大丈夫"},
+			"Line\n":      {MsgID: "Line\n", MsgStr: "行\n"},
+		},
+		"blog": {
+			"menu\x04Top": {Context: "menu", MsgID: "Top", MsgStr: "ブログ"},
+		},
+	}
+
+	for _, s := range []struct {
+		input  string
+		errors int
+	}{
+		{"__('Hello {0}', 'x')", 0},
+		{"__('Hello {0}', ['x'])", 0},
+		{"__('Hello {0}')", 1},
+		{"__('Unknown')", 1},
+		{"__x('menu', 'Top')", 0},
+		{"__('Top')", 1},
+		{"__dx('blog', 'menu', 'Top')", 0},
+		{"__dx('blog', 'other', 'Top')", 1},
+		{"__d('nothing', 'Top')", 1},
+		{"__n('{0} file', '{0} files', $n, $n)", 0},
+		{"__n('{0} file', '{0} filez', $n, $n)", 1},
+		{"__n('{0} file', '{0} files', $n)", 1},
+		{"__n('{0} file')", 1},
+		{"__dn('default', '{0} file', '{0} files', $n, $n,)", 0},
+		{"__('{0} file', 1)", 0}, // 警告のみ
+		{"__c('Hello {0}', 6, 'x')", 0},
+		{"__c('Hello {0}', 6)", 1},
+		{"__('Old')", 1},
+		{"__('Draft')", 0}, // 警告のみ
+		{`__('It\'s "ok"')`, 0},
+		{`__("It's \"ok\"")`, 0}, // 警告のみ
+		{`__("Line\n")`, 0},      // 警告のみ
+		{`__("Line\x0a")`, 0},    // 警告のみ
+		{`__('Line\n')`, 1},
+		{"__($msg)", 0},
+		{"__('Hello {0}', 'x'", 1},
+		{"__d(domain: 'blog', context: 'menu', msg: 'Top')", 1},
+		{"__dx(domain: 'blog', context: 'menu', msg: 'Top')", 0},
+		{"__dx(context: 'menu', msg: 'Top', domain: 'blog',)", 0},
+		{"__dx('blog', msg: 'Top', context: 'menu')", 0},
+		{"__x(singular: 'Top', context: 'menu')", 0},
+		{"__(singular: 'Hello {0}')", 1},
+		{"__(singular: 'Hello {0}', name: 'x')", 0},
+		{"__(\n  'Hello {0}', // comment\n  'x',\n)", 0},
+		{"__dx(domain: 'blog', 'menu', 'Top')", 1},
+		{"__dx('blog', 'menu', msg: 'Top', domain: 'x')", 1},
+		{"__dx('blog', context: 'menu')", 1},
+		{"__d(...$args)", 0},
+		{"__d('blog', ...$args)", 0},
+		{"__('Hello {0}', ...$args)", 0},
+		{"__('Hello {0}', ...$args, 'x')", 1},
+	} {
+		linter := newTestLinter()
+		config := NewConfig()
+		tokens := getTokens(NewLexer(strings.NewReader("<?php " + s.input)))
+		calls, _ := findCalls(linter, config, "a.php", tokens)
+		for _, c := range calls {
+			checkCall(linter, config, "a.php", c, entriesDict)
+		}
+		if n := linter.Reporter.CountError(); n != s.errors {
+			t.Errorf("input=%s: expect=%d actual=%d", s.input, s.errors, n)
+		}
+	}
+}
+
+func TestConfigLoad(t *testing.T) {
+	config := NewConfig()
+	err := config.Load(strings.NewReader(`{
+		"default_domain": "app",
+		"functions": {
+			"nc_trans": {"domain": 0, "msgid": 1, "args": 2},
+			"->titleIcon": {"domain": 1, "msgid": 2}
+		},
+		"classes": ["I18nHelper"]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.DefaultDomain != "app" {
+		t.Errorf("default domain=%s", config.DefaultDomain)
+	}
+	if f := config.Functions["->titleIcon"]; f == nil || !reflect.DeepEqual(*f, Function{Domain: 1, Context: -1, MsgID: 2, Plural: -1, Count: -1, Args: -1}) {
+		t.Errorf("titleIcon=%+v", f)
+	}
+	if _, ok := config.Functions["__d"]; !ok {
+		t.Errorf("__d was removed")
+	}
+
+	for _, input := range []string{
+		`{"functions": {"f": {"domain": 0}}}`,
+		`{"functions": {"f": {"domain": 0, "msgid": 0}}}`,
+		`{"functions": {"f": {"msgid": 0, "plural": 1}}}`,
+		`{"functions": {"f": {"msgid": 1, "args": 0}}}`,
+		`{"functions": {"f": {"msgid": 0, "msgstr": 1}}}`,
+		`{"function": {}}`,
+	} {
+		if err := NewConfig().Load(strings.NewReader(input)); err == nil {
+			t.Errorf("input=%s: expected error", input)
+		}
+	}
+
+	entriesDict := map[string]map[string]*com.PoEntry{
+		"blog": {"Hello {0}": {MsgID: "Hello {0}", MsgStr: "こんにちは {0}"}},
+	}
+	for _, s := range []struct {
+		input  string
+		errors int
+	}{
+		{"nc_trans('blog', 'Hello {0}', $x)", 0},
+		{"nc_trans('blog', 'Hello {0}')", 1},
+		{"nc_trans('blog', 'Unknown')", 1},
+		{"$this->Html->titleIcon('icon', 'blog', 'Hello {0}')", 0},
+		{"$this->Html->titleIcon('icon', 'blog', 'Unknown')", 1},
+		{"titleIcon('icon', 'blog', 'Unknown')", 0},
+		{"I18nHelper::__d('blog', 'Unknown')", 1},
+		{"Helper::__d('blog', 'Unknown')", 0},
+	} {
+		linter := newTestLinter()
+		tokens := getTokens(NewLexer(strings.NewReader("<?php " + s.input)))
+		calls, _ := findCalls(linter, config, "a.php", tokens)
+		for _, c := range calls {
+			checkCall(linter, config, "a.php", c, entriesDict)
+		}
+		if n := linter.Reporter.CountError(); n != s.errors {
+			t.Errorf("input=%s: expect=%d actual=%d", s.input, s.errors, n)
+		}
+	}
+}
+
+func TestLexer(t *testing.T) {
+	type Expect struct {
+		Type  TokenType
+		Value string
+	}
+
+	for _, s := range []struct {
+		input  string
+		expect []Expect
+	}{
+		// HTML 中のアポストロフィは文字列ではない
+		{"<p>It's <?= __d('a', 'b') ?>\n<p>don't</p>", []Expect{
+			{TOKEN_INLINE_HTML, "<p>It's "},
+			{TOKEN_KEYWORD, "echo"},
+			{TOKEN_IDENTIFIER, "__d"},
+			{TOKEN_SYMBOL, "("},
+			{TOKEN_STRING1, "a"},
+			{TOKEN_SYMBOL, ","},
+			{TOKEN_STRING1, "b"},
+			{TOKEN_SYMBOL, ")"},
+			{TOKEN_SYMBOL, ";"},
+			{TOKEN_INLINE_HTML, "<p>don't</p>"},
+		}},
+		{"<?xml version='1.0'?><?php\n# it's a comment\n// it's too ?>x", []Expect{
+			{TOKEN_INLINE_HTML, "<?xml version='1.0'?>"},
+			{TOKEN_SYMBOL, ";"},
+			{TOKEN_INLINE_HTML, "x"},
+		}},
+		{"<?php #[Attr] $x = '/* not a comment */'; /* it's */ `ls 'a`;", []Expect{
+			{TOKEN_SYMBOL, "#["},
+			{TOKEN_IDENTIFIER, "Attr"},
+			{TOKEN_SYMBOL, "]"},
+			{TOKEN_VARIABLE, "$x"},
+			{TOKEN_SYMBOL, "="},
+			{TOKEN_STRING1, "/* not a comment */"},
+			{TOKEN_SYMBOL, ";"},
+			{TOKEN_BACKTICK, "ls 'a"},
+			{TOKEN_SYMBOL, ";"},
+		}},
+		{"<?php $a?->b::c(...$d) <=> 0x1F + 1_000.5e-3;", []Expect{
+			{TOKEN_VARIABLE, "$a"},
+			{TOKEN_SYMBOL, "?->"},
+			{TOKEN_IDENTIFIER, "b"},
+			{TOKEN_SYMBOL, "::"},
+			{TOKEN_IDENTIFIER, "c"},
+			{TOKEN_SYMBOL, "("},
+			{TOKEN_SYMBOL, "..."},
+			{TOKEN_VARIABLE, "$d"},
+			{TOKEN_SYMBOL, ")"},
+			{TOKEN_SYMBOL, "<=>"},
+			{TOKEN_NUMBER, "0x1F"},
+			{TOKEN_SYMBOL, "+"},
+			{TOKEN_NUMBER, "1_000.5e-3"},
+			{TOKEN_SYMBOL, ";"},
+		}},
+		{"<?php \"a {$b['c\"']} \\\" d\" . 'e\\'f'", []Expect{
+			{TOKEN_STRING2, "a {$b['c\"']} \\\" d"},
+			{TOKEN_SYMBOL, "."},
+			{TOKEN_STRING1, "e\\'f"},
+		}},
+		{"<?php\n$a = <<<EOT\n  It's\n    ok\n  EOT;\n$b = <<<'EOT'\n{$x}\nEOT . <<<\"X\"\nX;", []Expect{
+			{TOKEN_VARIABLE, "$a"},
+			{TOKEN_SYMBOL, "="},
+			{TOKEN_HEREDOC, "It's\n  ok"},
+			{TOKEN_SYMBOL, ";"},
+			{TOKEN_VARIABLE, "$b"},
+			{TOKEN_SYMBOL, "="},
+			{TOKEN_NOWDOC, "{$x}"},
+			{TOKEN_SYMBOL, "."},
+			{TOKEN_HEREDOC, ""},
+			{TOKEN_SYMBOL, ";"},
+		}},
+		{"<?php $a << 2; $b <<<= 1;", []Expect{
+			{TOKEN_VARIABLE, "$a"},
+			{TOKEN_SYMBOL, "<<"},
+			{TOKEN_NUMBER, "2"},
+			{TOKEN_SYMBOL, ";"},
+			{TOKEN_VARIABLE, "$b"},
+			{TOKEN_SYMBOL, "<<"},
+			{TOKEN_SYMBOL, "<="},
+			{TOKEN_NUMBER, "1"},
+			{TOKEN_SYMBOL, ";"},
+		}},
+	} {
+		lexer := NewLexer(strings.NewReader(s.input))
+		tokens := make([]*Token, 0)
+		for tok := lexer.NextToken(); tok.Type != TOKEN_EOF; tok = lexer.NextToken() {
+			tokens = append(tokens, tok)
+		}
+
+		ok := len(tokens) == len(s.expect)
+		for i := 0; ok && i < len(tokens); i++ {
+			ok = tokens[i].Type == s.expect[i].Type && tokens[i].Value == s.expect[i].Value
+		}
+		if !ok {
+			actual := make([]Expect, 0, len(tokens))
+			for _, tok := range tokens {
+				actual = append(actual, Expect{tok.Type, tok.Value})
+			}
+			t.Errorf("\ninput =%q\nexpect=%q\nactual=%q\n", s.input, s.expect, actual)
+		}
+	}
+}
+
+func TestLexerPosition(t *testing.T) {
+	lexer := NewLexer(strings.NewReader("<p>あ</p>\n<?php\n  echo __d('a', 'b');"))
+	for tok := lexer.NextToken(); tok.Type != TOKEN_EOF; tok = lexer.NextToken() {
+		if tok.Value == "__d" {
+			if tok.Lnum != 3 || tok.Col != 8 {
+				t.Errorf("position: %d:%d", tok.Lnum, tok.Col)
+			}
+			return
+		}
+	}
+	t.Errorf("__d not found")
+}
+
+func TestInterpolation(t *testing.T) {
+	for _, s := range []struct {
+		input  string
+		expect []StringPart
+	}{
+		{`"abc"`, nil},
+		{`"a\$b"`, nil},
+		{`"$"`, nil},
+		{`"Hello $name!"`, []StringPart{{Str: "Hello "}, {Expr: "$name"}, {Str: "!"}}},
+		{`"$a[0]$b->c->d\n"`, []StringPart{{}, {Expr: "$a[0]"}, {}, {Expr: "$b->c"}, {Str: "->d\n"}}},
+		{`"{$user->name['}']} and ${x}"`, []StringPart{{}, {Expr: "{$user->name['}']}"}, {Str: " and "}, {Expr: "${x}"}, {}}},
+		{`'a' . "$b"`, []StringPart{{Str: "a"}, {}, {Expr: "$b"}, {}}},
+		{"<<<EOT\n$x\nEOT", []StringPart{{}, {Expr: "$x"}, {}}},
+		{"<<<'EOT'\n$x\nEOT", nil},
+	} {
+		tokens := getTokens(NewLexer(strings.NewReader("<?php " + s.input)))
+		if len(tokens) != 1 || !tokens[0].isString() {
+			t.Errorf("\ninput =%v\nactual=%v\n", s.input, tokens)
+			continue
+		}
+		actual := tokens[0].Parts
+		ok := len(actual) == len(s.expect)
+		for i := 0; ok && i < len(actual); i++ {
+			ok = actual[i] == s.expect[i]
+		}
+		if !ok {
+			t.Errorf("\ninput =%v\nexpect=%q\nactual=%q\n", s.input, s.expect, actual)
+		}
+	}
+
+	entriesDict := map[string]map[string]*com.PoEntry{
+		"blog": {"Hello": {MsgID: "Hello", MsgStr: "こんにちは"}},
+	}
+	for _, s := range []struct {
+		input  string
+		errors int
+		calls  int
+	}{
+		{`__d('blog', "Hello")`, 0, 1},
+		{`__d('blog', "Hello $name")`, 1, 0},
+		{`__d("$domain", 'Hello')`, 1, 0},
+		{`__d('blog', 'Hello' . $x)`, 0, 0}, // 警告のみ
+		{`__d('blog', $x . 'Hello')`, 0, 0}, // 警告のみ
+		{`__d('blog', $x)`, 0, 0},
+		{`__d('blog', f('a' . $x))`, 0, 0}, // 警告のみ
+	} {
+		linter := newTestLinter()
+		config := NewConfig()
+		tokens := getTokens(NewLexer(strings.NewReader("<?php " + s.input)))
+		calls, _ := findCalls(linter, config, "a.php", tokens)
+		for _, c := range calls {
+			checkCall(linter, config, "a.php", c, entriesDict)
+		}
+		if n := linter.Reporter.CountError(); n != s.errors || len(calls) != s.calls {
+			t.Errorf("input=%s: expect=%d,%d actual=%d,%d", s.input, s.errors, s.calls, n, len(calls))
+		}
+	}
+}
+
+func TestConstants(t *testing.T) {
+	input := `<?php
+define('APP_DOMAIN', 'blog');
+define('BAD', 'a');
+define('BAD', 'b');
+const TOP = 'Hello';
+
+class AccessCounter {
+    const DOMAIN = 'access_counters', OTHER = 'x';
+    public const string LABEL = 'Hello';
+
+    public function index($arg) {
+        $domain = 'access_counters';
+        $msg = 'Hello';
+        $twice = 'a';
+        $twice = 'b';
+        $appended = 'a';
+        $appended .= 'b';
+        foreach ($list as $item) {
+        }
+        echo __d($domain, $msg);
+        echo __d(self::DOMAIN, static::LABEL);
+        echo __d(AccessCounter::DOMAIN, TOP);
+        echo __d(APP_DOMAIN, 'Hello');
+        echo __d($twice, 'Hello');
+        echo __d($appended, 'Hello');
+        echo __d($item, 'Hello');
+        echo __d($arg, 'Hello');
+        echo __d(BAD, 'Hello');
+        echo __d(Other::DOMAIN, 'Hello');
+    }
+
+    public function other() {
+        echo __d($domain, 'Hello');
+    }
+}
+$domain = 'blog';
+echo __d($domain, 'Hello');
+`
+	linter := newTestLinter()
+	tokens := getTokens(NewLexer(strings.NewReader(input)))
+	calls, unresolved := findCalls(linter, NewConfig(), "a.php", tokens)
+
+	actual := make([]string, 0)
+	for _, c := range calls {
+		actual = append(actual, fmt.Sprintf("%d:%s", c.tok.Lnum, c.label()))
+	}
+	expect := []string{
+		"20:__d(access_counters,Hello)",
+		"21:__d(access_counters,Hello)",
+		"22:__d(access_counters,Hello)",
+		"23:__d(blog,Hello)",
+		"37:__d(blog,Hello)",
+	}
+	if strings.Join(actual, " ") != strings.Join(expect, " ") {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, actual)
+	}
+	if unresolved != 7 {
+		t.Errorf("unresolved: expect=7 actual=%d", unresolved)
+	}
+}
+
+func TestFindWrappers(t *testing.T) {
+	lib := `<?php
+function t($msgid, ...$args) {
+    return __d('plugin', $msgid, ...$args);
+}
+function dt($domain, $msgid, $args = []) {
+    return __d($domain, $msgid, $args);
+}
+function tt($msgid) {
+    return t($msgid);
+}
+function modified($msgid) {
+    $msgid = trim($msgid);
+    return __d('plugin', $msgid);
+}
+
+class BlogHelper {
+    const DOMAIN = 'helpers';
+
+    public function label($msgid, ...$args) {
+        return __d(self::DOMAIN, $msgid, ...$args);
+    }
+
+    public static function menu($msgid) {
+        return __dx('plugin', 'menu', $msgid);
+    }
+
+    abstract public function other($msgid);
+
+    public function render() {
+        echo $this->label('Hello {0}');
+        echo self::menu('Top');
+    }
+}
+
+class MailComponent {
+    public function label($msgid) {
+        return __d('mail', $msgid);
+    }
+}
+
+$anonymous = new class {
+    public function title($msgid) {
+        return __d('plugin', $msgid);
+    }
+};
+`
+	input := `<?php
+echo t('Hello {0}', $name);
+echo t('Hello {0}');
+echo dt('blog', 'Top', ['x']);
+echo tt('Top');
+echo modified('Top');
+echo $this->Form->label('email');
+echo label('Top');
+echo BlogHelper::menu('Top');
+echo t($msgid);
+echo $obj->menu('Top');
+echo title('email');
+echo $this->Blog->label('Hello {0}', $name);
+echo $this->Mail->label('Top');
+echo $anonymous->title('Top');
+`
+	linter := newTestLinter()
+	files := []*phpFile{
+		{filename: "lib.php", tokens: getTokens(NewLexer(strings.NewReader(lib)))},
+		{filename: "a.php", tokens: getTokens(NewLexer(strings.NewReader(input)))},
+	}
+	config := NewConfig()
+	config.AddClass("I18n")
+	nfunc := len(config.Functions)
+	wrapped := findWrappers(linter, config, files)
+	if len(config.Functions) != nfunc {
+		t.Errorf("config is modified: %v", config.Functions)
+	}
+	if !reflect.DeepEqual(wrapped.Classes, []string{"I18n"}) {
+		t.Errorf("classes=%v", wrapped.Classes)
+	}
+	if f := wrapped.Functions["t"]; f == nil || !reflect.DeepEqual(*f, Function{Domain: -1, Context: -1, MsgID: 0, Plural: -1, Count: -1, Args: 1, FixedDomain: "plugin", Params: []string{"msgid", "args"}}) {
+		t.Errorf("t=%+v", f)
+	}
+	// label は 2 つのクラスにあるので ->label と ::label は登録しない
+	for _, name := range []string{"->label", "::label", "label", "title"} {
+		if _, ok := wrapped.Functions[name]; ok {
+			t.Errorf("%s is registered", name)
+		}
+	}
+	for _, name := range []string{"BlogHelper::label", "MailComponent::label", "->menu", "::menu", "->title"} {
+		if _, ok := wrapped.Functions[name]; !ok {
+			t.Errorf("%s is not registered", name)
+		}
+	}
+
+	actual := make([]string, 0)
+	calls := make([]*call, 0)
+	unresolved := 0
+	for _, f := range files {
+		fcalls, n := findCalls(linter, wrapped, f.filename, f.tokens)
+		for _, c := range fcalls {
+			actual = append(actual, fmt.Sprintf("%s:%d:%s:%s\x04%s", f.filename, c.tok.Lnum, c.domainValue(wrapped), c.contextValue(), stringValue(c.msgid)))
+		}
+		calls = append(calls, fcalls...)
+		unresolved += n
+	}
+	expect := []string{
+		"lib.php:30:helpers:\x04Hello {0}",
+		"lib.php:31:plugin:menu\x04Top",
+		"a.php:2:plugin:\x04Hello {0}",
+		"a.php:3:plugin:\x04Hello {0}",
+		"a.php:4:blog:\x04Top",
+		"a.php:5:plugin:\x04Top",
+		"a.php:9:plugin:menu\x04Top",
+		"a.php:11:plugin:menu\x04Top",
+		"a.php:13:helpers:\x04Hello {0}",
+		"a.php:14:mail:\x04Top",
+		"a.php:15:plugin:\x04Top",
+	}
+	if strings.Join(actual, " ") != strings.Join(expect, " ") {
+		t.Errorf("\nexpect=%q\nactual=%q", expect, actual)
+	}
+	// ラッパーの中の呼び出し 8 つと t($msgid)
+	if unresolved != 9 {
+		t.Errorf("unresolved: expect=9 actual=%d", unresolved)
+	}
+
+	entriesDict := map[string]map[string]*com.PoEntry{
+		"plugin":  {"Hello {0}": {MsgID: "Hello {0}", MsgStr: "こんにちは {0}"}, "Top": {MsgID: "Top"}, "menu\x04Top": {Context: "menu", MsgID: "Top"}},
+		"blog":    {"Top": {MsgID: "Top"}},
+		"helpers": {"Hello {0}": {MsgID: "Hello {0}", MsgStr: "こんにちは {0}"}},
+		"mail":    {"Top": {MsgID: "Top"}},
+	}
+	for _, c := range calls {
+		checkCall(linter, wrapped, "a.php", c, entriesDict)
+	}
+	// t('Hello {0}') と $this->label('Hello {0}') の引数不足
+	if n := linter.Reporter.CountError(); n != 2 {
+		t.Errorf("errors: expect=2 actual=%d", n)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	input := `<?php
+function __d($domain, $msg, ...$args) {
+}
+function &__x($context, $msg) {
+}
+echo __d('blog', 'Top');
+echo \__d('blog', 'Top');
+echo App\__d('blog', 'Top');
+echo $obj->__d('blog', 'Top');
+echo $obj?->__d('blog', 'Top');
+echo Foo::__d('blog', 'Top');
+echo I18n::__d('blog', 'Top');
+echo \Cake\I18n::__d('blog', 'Top');
+echo new __d('blog', 'Top');
+class I18n {
+    public function index() {
+        echo $this->__d('blog', 'Top');
+        echo self::__d('blog', 'Top');
+        echo parent::__d('blog', 'Top');
+    }
+}
+class Other {
+    public function index() {
+        echo $this->__d('blog', 'Top');
+        echo static::__d('blog', 'Top');
+    }
+}
+`
+	linter := newTestLinter()
+	tokens := getTokens(NewLexer(strings.NewReader(input)))
+	config := NewConfig()
+	config.AddClass("I18n")
+	calls, _ := findCalls(linter, config, "a.php", tokens)
+
+	actual := make([]int, 0)
+	for _, c := range calls {
+		actual = append(actual, c.tok.Lnum)
+	}
+	expect := []int{6, 7, 12, 13, 17, 18}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf("expect=%v actual=%v", expect, actual)
+	}
+	if n := linter.Reporter.CountError(); n != 0 {
+		t.Errorf("errors: %d", n)
+	}
+}
+
+// アロー関数の引数や分割代入で変数を束縛し直したら解決しない
+func TestConstantsRebind(t *testing.T) {
+	for _, input := range []string{
+		"$domain = 'blog';\n$f = fn($domain) => __d($domain, 'Hello');",
+		"$domain = 'blog';\n$f = fn&($domain) => __d($domain, 'Hello');",
+		"$domain = 'blog';\nlist($domain, $msg) = $pair;\necho __d($domain, 'Hello');",
+		"$domain = 'blog';\nlist('d' => $domain) = $pair;\necho __d($domain, 'Hello');",
+		"$domain = 'blog';\n[$domain, $msg] = $pair;\necho __d($domain, 'Hello');",
+		"$domain = 'blog';\n[[$domain], $msg] = $pair;\necho __d($domain, 'Hello');",
+		"function f() {\n    $domain = 'blog';\n    [, $domain] = $pair;\n    echo __d($domain, 'Hello');\n}",
+		"$domain = 'blog';\nforeach ($list as $key => $domain) {\n}\necho __d($domain, 'Hello');",
+	} {
+		tokens := getTokens(NewLexer(strings.NewReader("<?php " + input)))
+		calls, unresolved := findCalls(newTestLinter(), NewConfig(), "a.php", tokens)
+		if len(calls) != 0 || unresolved != 1 {
+			t.Errorf("input=%s: calls=%d unresolved=%d", input, len(calls), unresolved)
+		}
+	}
+
+	for _, input := range []string{
+		// 添字への代入は束縛し直さない
+		"$domain = 'blog';\n$list[$domain] = 1;\n$f = fn($x) => $x;\necho __d($domain, 'Hello');",
+		// foreach 以外の as
+		"use App\\Foo as Bar;\n$domain = 'blog';\necho __d($domain, 'Hello');",
+		"class A {\n    use T { T::f as g; }\n    function h() {\n        $domain = 'blog';\n        echo __d($domain, 'Hello');\n    }\n}",
+		"foreach ($list as $item) {\n}\n$domain = 'blog';\necho __d($domain, 'Hello');",
+	} {
+		tokens := getTokens(NewLexer(strings.NewReader("<?php " + input)))
+		calls, _ := findCalls(newTestLinter(), NewConfig(), "a.php", tokens)
+		if len(calls) != 1 || calls[0].domainValue(NewConfig()) != "blog" {
+			t.Errorf("input=%s: calls=%v", input, calls)
+		}
+	}
+}
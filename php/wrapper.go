@@ -0,0 +1,271 @@
+package php
+
+import (
+	"polinco/com"
+	"reflect"
+	"strings"
+)
+
+// /////////////////////////////////////////////////////////////
+// ラッパー関数
+// /////////////////////////////////////////////////////////////
+
+// 関数やメソッドの宣言
+type funcDecl struct {
+	tok    *Token // 関数名
+	class  string // メソッドならクラス名
+	params []param
+
+	tokens []*Token
+	open   int // 本体の { の位置
+	close  int // 本体の } の位置
+	consts *constants
+}
+
+// 仮引数
+type param struct {
+	name     string // $name
+	variadic bool   // ...$name
+	assigned bool   // 本体で書き換えている
+}
+
+// 翻訳関数として登録する名前.
+// メソッドは設定したクラスと同じく "Class::name" にして, そのクラスの呼び出しだけを調べる.
+// methods はメソッド名 (小文字) ごとの宣言の数. 同じ名前のメソッドが他に無ければ
+// $obj->name() なども調べる. 無名クラスは呼び出し側からクラスが分からない
+func (d *funcDecl) names(methods map[string]int) []string {
+	if d.class == "" {
+		return []string{d.tok.Value}
+	}
+	names := make([]string, 0)
+	if d.class != anonymousClass {
+		names = append(names, d.class+"::"+d.tok.Value)
+	}
+	if methods[strings.ToLower(d.tok.Value)] == 1 {
+		names = append(names, "->"+d.tok.Value, "::"+d.tok.Value)
+	}
+	return names
+}
+
+// 本体のある名前付きの関数とメソッドを探す. クロージャは除く
+func findFuncDecls(tokens []*Token) []*funcDecl {
+	decls := make([]*funcDecl, 0)
+	consts := newConstants(tokens)
+	for i, t := range tokens {
+		if !t.isType(TOKEN_KEYWORD) || !strings.EqualFold(t.Value, "function") {
+			continue
+		}
+		j := i + 1
+		if j < len(tokens) && tokens[j].is(TOKEN_SYMBOL, "&") {
+			j++
+		}
+		if j+1 >= len(tokens) || !tokens[j].isType(TOKEN_IDENTIFIER) || !tokens[j+1].is(TOKEN_SYMBOL, "(") {
+			continue
+		}
+		end := closing(tokens, j+1)
+		if end < 0 {
+			continue
+		}
+
+		// 戻り値の型を飛ばす. ; なら抽象メソッド
+		open := end + 1
+		for open < len(tokens) && !tokens[open].is(TOKEN_SYMBOL, "{") && !tokens[open].is(TOKEN_SYMBOL, ";") {
+			open++
+		}
+		if open >= len(tokens) || !tokens[open].is(TOKEN_SYMBOL, "{") {
+			continue
+		}
+		close := closing(tokens, open)
+		if close < 0 {
+			continue
+		}
+
+		d := &funcDecl{tok: tokens[j], class: consts.class[i], tokens: tokens, open: open, close: close, consts: consts}
+		args, _ := splitArgs(tokens[j+2 : end+1])
+		for _, arg := range args {
+			p := param{}
+			for k, t := range arg {
+				if t.isType(TOKEN_VARIABLE) {
+					p.name = t.Value
+					p.variadic = k > 0 && arg[k-1].is(TOKEN_SYMBOL, "...")
+					break
+				}
+			}
+			d.params = append(d.params, p)
+		}
+
+		for k := open + 1; k < close; k++ {
+			if !tokens[k].isType(TOKEN_VARIABLE) {
+				continue
+			}
+			next := tokens[k+1]
+			if tokens[k-1].is(TOKEN_SYMBOL, "&") || next.isType(TOKEN_SYMBOL) && (next.Value == "=" || assignOps[next.Value]) {
+				for n := range d.params {
+					if d.params[n].name == tokens[k].Value {
+						d.params[n].assigned = true
+					}
+				}
+			}
+		}
+		decls = append(decls, d)
+	}
+	return decls
+}
+
+// arg が書き換えていない仮引数そのものなら, その位置. 違えば -1
+func (d *funcDecl) param(arg []*Token, variadic bool) int {
+	if len(arg) != 1 || !arg[0].isType(TOKEN_VARIABLE) {
+		return -1
+	}
+	for n, p := range d.params {
+		if p.name == arg[0].Value {
+			if p.assigned || p.variadic != variadic {
+				return -1
+			}
+			return n
+		}
+	}
+	return -1
+}
+
+// tokens[i] の位置で arg の値が文字列に決まれば, その値
+func (d *funcDecl) literal(arg []*Token, i int) (string, bool) {
+	if v, ok := literalValue(arg); ok {
+		return v, true
+	}
+	if t, ok := d.consts.resolve(arg, i); ok {
+		return t.Str, true
+	}
+	return "", false
+}
+
+// 本体で仮引数の msgid をそのまま翻訳関数に渡していれば, 翻訳関数としての引数の並び
+func (d *funcDecl) wrapper(config *Config) *Function {
+	tokens := d.tokens
+	for i := d.open + 1; i < d.close; i++ {
+		if !tokens[i].isType(TOKEN_IDENTIFIER) || !tokens[i+1].is(TOKEN_SYMBOL, "(") {
+			continue
+		}
+		fn, ok := config.lookup(tokens, i, d.consts.class[i])
+		if !ok {
+			continue
+		}
+		args, ok := parseArgs(tokens[i+2:])
+		if !ok {
+			continue
+		}
+		b, err := bindArgs(fn, args)
+		if err != nil || b == nil || !fn.filled(b) {
+			continue
+		}
+		if w := d.wrap(fn, b, i); w != nil {
+			return w
+		}
+	}
+	return nil
+}
+
+// tokens[i] の fn(args) の呼び出しをラッパーの引数の並びに置き換える
+func (d *funcDecl) wrap(fn *Function, b *boundArgs, i int) *Function {
+	w := &Function{Domain: -1, Context: -1, MsgID: -1, Plural: -1, Count: -1, Args: -1, FixedDomain: fn.FixedDomain, FixedContext: fn.FixedContext}
+	for _, p := range d.params {
+		w.Params = append(w.Params, strings.TrimPrefix(p.name, "$"))
+	}
+
+	if w.MsgID = d.param(b.value(fn.MsgID), false); w.MsgID < 0 {
+		return nil
+	}
+	if fn.Plural >= 0 {
+		if w.Plural = d.param(b.value(fn.Plural), false); w.Plural < 0 {
+			return nil
+		}
+		w.Count = d.param(b.value(fn.Count), false)
+	}
+
+	// ドメインと msgctxt は仮引数か決まった値
+	for _, v := range []struct {
+		pos   int
+		ptr   *int
+		fixed *string
+	}{
+		{fn.Domain, &w.Domain, &w.FixedDomain},
+		{fn.Context, &w.Context, &w.FixedContext},
+	} {
+		if v.pos < 0 {
+			continue
+		}
+		if *v.ptr = d.param(b.value(v.pos), false); *v.ptr >= 0 {
+			*v.fixed = ""
+			continue
+		}
+		value, ok := d.literal(b.value(v.pos), i)
+		if !ok {
+			return nil
+		}
+		*v.fixed = value
+	}
+
+	// 置き換える値を可変長引数 ...$args か配列 $args でそのまま渡していれば数を確認できる
+	if len(b.rest) == 1 && b.rest[0].name == "" {
+		w.Args = d.param(b.rest[0].tokens, b.rest[0].spread)
+		if w.Args >= 0 && w.Args < w.required() {
+			w.Args = -1
+		}
+	}
+	return w
+}
+
+// files 中のラッパー関数を翻訳関数として加えた設定を返す.
+// ラッパーを呼ぶラッパーも見つかるように, 増えなくなるまで繰り返す
+func findWrappers(linter *com.Linter, config *Config, files []*phpFile) *Config {
+	decls := make([]*funcDecl, 0)
+	owner := make(map[*funcDecl]string)
+	methods := make(map[string]int)
+	for _, f := range files {
+		for _, d := range findFuncDecls(f.tokens) {
+			decls = append(decls, d)
+			owner[d] = f.filename
+			if d.class != "" {
+				methods[strings.ToLower(d.tok.Value)]++
+			}
+		}
+	}
+
+	config = config.clone()
+	found := make(map[string]*Function)
+	ambiguous := make(map[string]bool) // 同じ名前で引数の並びが違うメソッドがある
+	done := make(map[*funcDecl]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, d := range decls {
+			if done[d] {
+				continue
+			}
+			w := d.wrapper(config)
+			if w == nil {
+				continue
+			}
+			done[d] = true
+			changed = true
+
+			for _, name := range d.names(methods) {
+				if ambiguous[name] {
+					continue
+				}
+				if _, ok := config.Functions[name]; ok {
+					// 設定した関数は上書きしない
+					if f, ok := found[name]; ok && !reflect.DeepEqual(f, w) {
+						linter.Dprintf("%s:%d: %s() has different signatures; not treated as a wrapper\n", owner[d], d.tok.Lnum, d.tok.Value)
+						ambiguous[name] = true
+						delete(config.Functions, name)
+					}
+					continue
+				}
+				linter.Dprintf("%s:%d: %s() is a wrapper of a translation function\n", owner[d], d.tok.Lnum, d.tok.Value)
+				config.Functions[name] = w
+				found[name] = w
+			}
+		}
+	}
+	return config
+}
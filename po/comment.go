@@ -0,0 +1,65 @@
+package po
+
+import (
+	"polinco/com"
+	"strings"
+)
+
+// コメント行 (先頭の # を除いたもの) を解析して entry に設定する
+func setComments(e *com.PoEntry, lines []string) {
+	prev := make([]string, 0)
+	for _, line := range lines {
+		if line == "" {
+			e.Comments = append(e.Comments, "")
+			continue
+		}
+		switch line[0] {
+		case '.':
+			e.ExtractedComments = append(e.ExtractedComments, trimComment(line[1:]))
+		case ':':
+			e.References = append(e.References, strings.Fields(line[1:])...)
+		case ',':
+			for _, flag := range strings.Split(line[1:], ",") {
+				if flag = strings.TrimSpace(flag); flag != "" {
+					e.Flags = append(e.Flags, flag)
+				}
+			}
+		case '|':
+			prev = append(prev, line[1:])
+		default:
+			e.Comments = append(e.Comments, trimComment(line))
+		}
+	}
+
+	if len(prev) > 0 {
+		setPrevious(e, strings.Join(prev, "\n"))
+	}
+}
+
+func trimComment(s string) string {
+	return strings.TrimPrefix(s, " ")
+}
+
+// #| msgctxt "..." / #| msgid "..." / #| msgid_plural "..."
+// 本体と同じ字句解析機で読む
+func setPrevious(e *com.PoEntry, src string) {
+	l := newLexer(strings.NewReader(src))
+	var lval yySymType
+	var target *string
+	for {
+		switch l.Lex(&lval) {
+		case MSGCTXT:
+			target = &e.PrevContext
+		case MSGID:
+			target = &e.PrevMsgID
+		case MSGID_PLURAL:
+			target = &e.PrevMsgIDPlural
+		case STRING:
+			if target != nil {
+				*target += lval.node.str
+			}
+		default:
+			return
+		}
+	}
+}
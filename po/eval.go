@@ -1,18 +1,80 @@
 package po
 
 import (
+	"bytes"
 	"io"
 	"polinco/com"
+	"sync"
 )
 
-func ParsePo(r io.Reader) ([]*com.PoEntry, error) {
-	lexer := newLexer(r)
-	poEntries = make([]*com.PoEntry, 0)
+// *.po ファイルひとつ分
+type File struct {
+	Header      *Header        // ヘッダが無い場合は nil
+	Entries     []*com.PoEntry // ヘッダを除く
+	Diagnostics []Diagnostic   // 構文エラー, 不正なエスケープシーケンスなど
+
+	// 読み込んだときの表記. Write で元通りに書き出すため
+	raw         map[*com.PoEntry]*rawEntry
+	trailer     string // 最後のエントリより後ろ
+	headerIndex int    // ヘッダの前にあったエントリの数
+}
+
+// 構文解析の設定. 複数の goroutine から同時に使ってよい.
+// ただし DebugLevel か ErrorVerbose を設定すると, その解析が終わるまで
+// 他の Parse (ParsePo を含む) はすべて待たされる
+type Parser struct {
+	DebugLevel   int  // goyacc の yyDebug
+	ErrorVerbose bool // goyacc の yyErrorVerbose
+}
+
+// goyacc の yyDebug, yyErrorVerbose はパッケージ変数なので,
+// デバッグ出力する場合のみ排他的に書き換える
+var yyDebugLock sync.RWMutex
+
+func ParsePo(r io.Reader) (*File, error) {
+	return (&Parser{}).Parse(r)
+}
+
+func (p *Parser) Parse(r io.Reader) (*File, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lexer := newLexer(bytes.NewReader(src))
+	if p.DebugLevel != 0 || p.ErrorVerbose {
+		yyDebugLock.Lock()
+		defer yyDebugLock.Unlock()
+		yyDebug, yyErrorVerbose = p.DebugLevel, p.ErrorVerbose
+		defer func() {
+			yyDebug, yyErrorVerbose = 0, false
+		}()
+	} else {
+		yyDebugLock.RLock()
+		defer yyDebugLock.RUnlock()
+	}
+
 	yyParse(lexer)
-	if lexer.err != nil {
-		return nil, lexer.err
+
+	// 構文エラーがあっても回復できた分は返す.
+	// エラーの詳細は Diagnostics を参照
+	f := &File{Entries: make([]*com.PoEntry, 0, len(lexer.entries)), Diagnostics: lexer.diagnostics}
+	f.raw = make(map[*com.PoEntry]*rawEntry, len(lexer.entries))
+	prev := 0
+	for i, entry := range lexer.entries {
+		if f.Header == nil && isHeaderEntry(entry) {
+			f.Header = newHeader(entry)
+			f.headerIndex = len(f.Entries)
+		} else {
+			f.Entries = append(f.Entries, entry)
+		}
+
+		span := lexer.spans[i]
+		f.raw[entry] = newRawEntry(entry, string(src[prev:span[0]]), string(src[span[0]:span[1]]))
+		prev = span[1]
 	}
-	return poEntries, nil
+	f.trailer = string(src[prev:])
+	return f, lexer.err
 }
 
 /**
@@ -34,8 +96,3 @@ func ParsePo(r io.Reader) ([]*com.PoEntry, error) {
 	return nil, nil
 }
 */
-
-func Debug(level int, verbose bool) {
-	yyDebug = level
-	yyErrorVerbose = verbose
-}
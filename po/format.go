@@ -0,0 +1,158 @@
+package po
+
+import (
+	"bufio"
+	"io"
+	"polinco/com"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// エントリの並べ方
+const (
+	SortNone      = ""      // 元の順
+	SortMsgID     = "msgid" // msgid 順
+	SortReference = "ref"   // 最初の #: の file:line 順
+)
+
+type FormatOption struct {
+	Width int    // 文字列を折り返す幅. 0 なら改行でのみ分ける
+	Sort  string // SortNone, SortMsgID, SortReference
+}
+
+// msginit が書き出す順. これ以外のフィールドは後ろに元の順で並べる
+var headerOrder = []string{
+	"Project-Id-Version",
+	"Report-Msgid-Bugs-To",
+	"POT-Creation-Date",
+	"PO-Revision-Date",
+	"Last-Translator",
+	"Language-Team",
+	"Language",
+	"MIME-Version",
+	"Content-Type",
+	"Content-Transfer-Encoding",
+	"Plural-Forms",
+}
+
+// f を決まった書式で書き出す. Write と異なり元の表記は使わない.
+// f は変更しない
+func Format(w io.Writer, f *File, opt FormatOption) error {
+	bw := bufio.NewWriter(w)
+
+	entries := make([]*com.PoEntry, 0, len(f.Entries)+1)
+	if f.Header != nil {
+		entries = append(entries, formatHeader(f.Header))
+	}
+	entries = append(entries, sortEntries(f.Entries, opt.Sort)...)
+
+	for i, e := range entries {
+		if i > 0 {
+			bw.WriteString("\n\n")
+		}
+		writeEntry(bw, e, opt.Width)
+	}
+	if len(entries) > 0 {
+		bw.WriteString("\n")
+	}
+
+	// 最後のエントリより後ろのコメントはそのまま残す
+	if trailer := strings.Trim(f.trailer, " \t\r\n"); trailer != "" {
+		if len(entries) > 0 {
+			bw.WriteString("\n")
+		}
+		bw.WriteString(trailer + "\n")
+	}
+	return bw.Flush()
+}
+
+// フィールドを並べ替えたヘッダのエントリ
+func formatHeader(h *Header) *com.PoEntry {
+	rank := func(key string) int {
+		for i, k := range headerOrder {
+			if strings.EqualFold(k, key) {
+				return i
+			}
+		}
+		return len(headerOrder)
+	}
+
+	// key: value の形でない行は直前のフィールドと一緒に動かす
+	type rankedField struct {
+		HeaderField
+		rank int
+	}
+	ranked := make([]rankedField, len(h.Fields))
+	prev := -1
+	for i, f := range h.Fields {
+		r := prev
+		if f.Key != "" || prev < 0 {
+			r = rank(f.Key)
+		}
+		ranked[i] = rankedField{f, r}
+		prev = r
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].rank < ranked[j].rank
+	})
+	fields := make([]HeaderField, len(ranked))
+	for i, f := range ranked {
+		fields[i] = f.HeaderField
+	}
+
+	e := copyEntry(h.Entry)
+	hh := &Header{Entry: &e, Fields: fields}
+	hh.update()
+	return hh.Entry
+}
+
+// 廃止されたエントリは常に最後
+func sortEntries(entries []*com.PoEntry, order string) []*com.PoEntry {
+	ret := append([]*com.PoEntry{}, entries...)
+	var less func(a, b *com.PoEntry) bool
+	switch order {
+	case SortMsgID:
+		less = func(a, b *com.PoEntry) bool {
+			if a.MsgID != b.MsgID {
+				return a.MsgID < b.MsgID
+			}
+			return a.Context < b.Context
+		}
+	case SortReference:
+		less = func(a, b *com.PoEntry) bool {
+			fa, la := firstReference(a)
+			fb, lb := firstReference(b)
+			if fa != fb {
+				return fa < fb
+			}
+			return la < lb
+		}
+	default:
+		less = func(a, b *com.PoEntry) bool {
+			return false
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Obsolete != ret[j].Obsolete {
+			return !ret[i].Obsolete
+		}
+		return less(ret[i], ret[j])
+	})
+	return ret
+}
+
+// #: file:line の file と line. 無い場合は "", 0
+func firstReference(e *com.PoEntry) (string, int) {
+	if len(e.References) == 0 {
+		return "", 0
+	}
+	ref := e.References[0]
+	if n := strings.LastIndex(ref, ":"); n > 0 {
+		if line, err := strconv.Atoi(ref[n+1:]); err == nil {
+			return ref[:n], line
+		}
+	}
+	return ref, 0
+}
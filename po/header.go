@@ -0,0 +1,129 @@
+package po
+
+import (
+	"polinco/com"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// /////////////////////////////////////////////////////////////
+// ヘッダ (msgid "" のエントリ)
+// /////////////////////////////////////////////////////////////
+type HeaderField struct {
+	Key   string // "" なら key: value の形でない行. Value にそのまま入れる
+	Value string
+}
+
+type Header struct {
+	Entry  *com.PoEntry
+	Fields []HeaderField // 出現順
+}
+
+func isHeaderEntry(e *com.PoEntry) bool {
+	return e.MsgID == "" && e.Context == "" && !e.Obsolete && !e.IsPlural()
+}
+
+func newHeader(e *com.PoEntry) *Header {
+	h := &Header{Entry: e}
+	for _, line := range strings.Split(e.MsgStr, "\n") {
+		if line == "" {
+			continue
+		}
+		n := strings.Index(line, ":")
+		if n < 0 {
+			h.Fields = append(h.Fields, HeaderField{Value: line})
+			continue
+		}
+		key := strings.TrimSpace(line[:n])
+		value := strings.TrimSpace(line[n+1:])
+		h.Fields = append(h.Fields, HeaderField{Key: key, Value: value})
+	}
+	return h
+}
+
+// fields からヘッダのエントリを作る
+func NewHeader(fields []HeaderField) *Header {
+	h := &Header{Entry: &com.PoEntry{}, Fields: fields}
+	h.update()
+	return h
+}
+
+// 値を変更する. 無い場合は末尾に追加する.
+// Entry.MsgStr も書き換える
+func (h *Header) Set(key, value string) {
+	found := false
+	for i, f := range h.Fields {
+		if f.Key != "" && strings.EqualFold(f.Key, key) {
+			h.Fields[i].Value = value
+			found = true
+			break
+		}
+	}
+	if !found {
+		h.Fields = append(h.Fields, HeaderField{Key: key, Value: value})
+	}
+	h.update()
+}
+
+// Fields から msgstr を作り直す
+func (h *Header) update() {
+	var sb strings.Builder
+	for _, f := range h.Fields {
+		if f.Key == "" {
+			sb.WriteString(f.Value + "\n")
+			continue
+		}
+		sb.WriteString(f.Key + ": " + f.Value + "\n")
+	}
+	h.Entry.MsgStr = sb.String()
+}
+
+// key は大文字小文字を区別しない
+func (h *Header) Get(key string) (string, bool) {
+	for _, f := range h.Fields {
+		if f.Key != "" && strings.EqualFold(f.Key, key) {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Content-Type: text/plain; charset=UTF-8
+func (h *Header) Charset() string {
+	ct, ok := h.Get("Content-Type")
+	if !ok {
+		return ""
+	}
+	for _, v := range strings.Split(ct, ";") {
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(strings.ToLower(v), "charset=") {
+			return v[len("charset="):]
+		}
+	}
+	return ""
+}
+
+func (h *Header) Language() string {
+	v, _ := h.Get("Language")
+	return v
+}
+
+var pluralFormsRegexp = regexp.MustCompile(`^\s*nplurals\s*=\s*([0-9]+)\s*;\s*plural\s*=\s*([^;]+?)\s*;?\s*$`)
+
+// Plural-Forms: nplurals=2; plural=(n != 1);
+// 書式が正しくない場合は ok=false
+func ParsePluralForms(s string) (nplurals int, plural string, ok bool) {
+	m := pluralFormsRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, "", false
+	}
+	nplurals, err := strconv.Atoi(m[1])
+	if err != nil || nplurals < 1 {
+		return 0, "", false
+	}
+	if _, err := ParsePluralExpr(m[2]); err != nil {
+		return 0, "", false
+	}
+	return nplurals, m[2], true
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"polinco/com"
 	"strconv"
+	"strings"
 	"text/scanner"
 )
 
@@ -13,10 +14,13 @@ import (
 // NODE
 // /////////////////////////////////////////////////////////////
 type pNode struct {
-	cmd   int
-	extra int
-	str   string
-	pos   scanner.Position
+	cmd      int
+	extra    int
+	str      string
+	pos      scanner.Position
+	obsolete bool // #~ の行のトークン
+	start    int  // ソース中のバイト位置 [start, end)
+	end      int
 }
 
 func newPNode(str string, cmd, extra int, pos scanner.Position) pNode {
@@ -38,38 +42,113 @@ type pLexer struct {
 	err         error
 	varmap      map[string]string
 	print_trace bool
+	obsolete    bool             // #~ の行を読んでいる
+	tokPos      scanner.Position // 最後に読んだトークンの位置
+
+	// 構文解析の結果
+	entries     []*com.PoEntry
+	spans       [][2]int // entries のソース中のバイト位置
+	diagnostics []Diagnostic
+}
+
+// 構文解析を継続できる誤り
+type Diagnostic struct {
+	Pos   scanner.Position
+	Level int // com.LevelError など
+	Msg   string
+}
+
+func (l *pLexer) addEntry(e *com.PoEntry, start, end int) {
+	l.entries = append(l.entries, e)
+	l.spans = append(l.spans, [2]int{start, end})
 }
 
 func (l *pLexer) skip_space() {
-	for {
-		for l.IsSpace(l.Peek()) {
-			l.Next()
-		}
-		if l.Peek() != '#' {
-			break
-		}
-		for l.Peek() != '\n' { // 改行までコメント
-			l.Next()
+	for l.IsSpace(l.Peek()) {
+		if l.Next() == '\n' {
+			l.obsolete = false
 		}
 	}
 }
 
 // 字句解析機
 func (l *pLexer) Lex(lval *yySymType) int {
+	tok := l.lex(lval)
+	lval.node.obsolete = l.obsolete
+	lval.node.start = l.tokPos.Offset
+	lval.node.end = l.Pos().Offset
+	return tok
+}
+
+func (l *pLexer) lex(lval *yySymType) int {
 	l.skip_space()
+	l.tokPos = l.Pos()
 
 	c := l.Peek()
 	l.trace(fmt.Sprintf("lex: go! %c", c))
+	if c == '#' {
+		pos := l.Pos()
+		l.Next()
+		if l.Peek() == '~' && !l.obsolete {
+			// #~ 廃止されたエントリ. 行の残りを通常どおり読む
+			// #~| は廃止されたエントリの以前の msgid
+			l.Next()
+			l.obsolete = true
+			if l.Peek() != '|' {
+				l.trace("lex:obsolete")
+				tok := l.lex(lval)
+				if l.obsolete {
+					// 同じ行の最初のトークンは #~ から始まる
+					l.tokPos = pos
+				}
+				return tok
+			}
+		}
+		var ret []rune
+		for l.Peek() != '\n' && l.Peek() != scanner.EOF { // 改行までコメント
+			ret = append(ret, l.Next())
+		}
+		str := string(ret)
+		l.trace("lex:comment:" + str)
+		lval.node = newPNode(str, COMMENT, 0, pos)
+		return COMMENT
+	}
 	if l.IsAlpha(l.Peek()) { // 英字
 		var ret []rune
 		for l.IsDigit(l.Peek()) || l.IsLetter(l.Peek()) {
 			ret = append(ret, l.Next())
 		}
 		str := string(ret)
-		if str == "msgid" {
+		if str == "msgctxt" {
+			l.trace("lex:msgctxt")
+			lval.node = newPNode(str, MSGCTXT, 0, l.Pos())
+			return MSGCTXT
+		} else if str == "msgid" {
 			l.trace("lex:msgid")
 			lval.node = newPNode(str, MSGID, 0, l.Pos())
 			return MSGID
+		} else if str == "msgid_plural" {
+			l.trace("lex:msgid_plural")
+			lval.node = newPNode(str, MSGID_PLURAL, 0, l.Pos())
+			return MSGID_PLURAL
+		} else if str == "msgstr" && l.Peek() == '[' {
+			// msgstr[N]
+			pos := l.Pos()
+			l.Next()
+			n := 0
+			digits := 0
+			for l.IsDigit(l.Peek()) {
+				n = n*10 + int(l.Next()-'0')
+				digits++
+			}
+			if digits == 0 || l.Peek() != ']' {
+				l.trace("lex:unknown:msgstr[")
+				return int('[')
+			}
+			l.Next()
+			l.trace(fmt.Sprintf("lex:msgstr[%d]", n))
+			lval.node = newPNode(str, MSGSTR_N, n, pos)
+			return MSGSTR_N
 		} else if str == "msgstr" {
 			l.trace("lex:msgstr")
 			lval.node = newPNode(str, MSGSTR, 0, l.Pos())
@@ -81,39 +160,108 @@ func (l *pLexer) Lex(lval *yySymType) int {
 		}
 	}
 	if l.Peek() == '"' {
-		sbuf := make([]rune, 0)
-		l.Next()
-		escape := false
-		for (l.Peek() != '"' || escape) && l.Peek() != scanner.EOF {
-			d := l.Next()
-
-			// \" を " に変換.
-			// 呼び出し元ではシングルクォートな文字列になることを想定
-			if escape && d == '"' {
-				sbuf[len(sbuf)-1] = d
-				escape = false
-				continue
-			}
-
-			if d == '\\' {
-				escape = !escape
-			} else {
-				escape = false
-			}
-			sbuf = append(sbuf, d)
-		}
 		l.Next()
-		str := string(sbuf)
+		str := l.scanString()
 		l.trace("lex:string:" + str)
 		lval.node = newPNode(str, STRING, 0, l.Pos())
 		return STRING
 	}
 	l.trace("lex:unknown:" + string(c))
+	if c != scanner.EOF {
+		// 読み飛ばさないとエラー回復で無限ループする
+		l.Next()
+	}
 	return int(c)
 }
 
+// "..." の中身を読み, エスケープシーケンスを gettext が返す値に変換する
+func (l *pLexer) scanString() string {
+	var sb strings.Builder
+	for {
+		pos := l.Pos()
+		c := l.Next()
+		switch c {
+		case '"':
+			return sb.String()
+		case '\n', scanner.EOF:
+			l.diag(pos, com.LevelError, "unterminated string")
+			return sb.String()
+		case '\\':
+			l.scanEscape(&sb, pos)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+}
+
+func (l *pLexer) scanEscape(sb *strings.Builder, pos scanner.Position) {
+	c := l.Peek()
+	switch c {
+	case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\', '"', '\'', '?':
+		l.Next()
+		sb.WriteByte(simpleEscapes[c])
+	case 'x':
+		l.Next()
+		v, n := 0, 0
+		for ; isHexDigit(l.Peek()); n++ {
+			v = v*16 + hexValue(l.Next())
+		}
+		if n == 0 {
+			l.diag(pos, com.LevelError, "invalid escape sequence: \\x without hex digits")
+			sb.WriteString(`\x`)
+			return
+		}
+		if v > 0xff {
+			l.diag(pos, com.LevelError, fmt.Sprintf("hex escape sequence out of range: \\x%x", v))
+		}
+		sb.WriteByte(byte(v))
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		v := 0
+		for n := 0; n < 3 && '0' <= l.Peek() && l.Peek() <= '7'; n++ {
+			v = v*8 + int(l.Next()-'0')
+		}
+		if v > 0xff {
+			l.diag(pos, com.LevelError, fmt.Sprintf("octal escape sequence out of range: \\%o", v))
+		}
+		sb.WriteByte(byte(v))
+	default:
+		// 不正なエスケープはそのまま残す
+		l.diag(pos, com.LevelError, fmt.Sprintf("invalid escape sequence: \\%c", c))
+		sb.WriteByte('\\')
+	}
+}
+
+var simpleEscapes = map[rune]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '"': '"', '\'': '\'', '?': '?',
+}
+
+func isHexDigit(c rune) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func hexValue(c rune) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
+
+func (l *pLexer) diag(pos scanner.Position, level int, msg string) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{Pos: pos, Level: level, Msg: msg})
+}
+
+// 構文エラー. エラー回復して解析を続けるので, すべて記録する
 func (l *pLexer) Error(s string) {
-	pos := l.Pos()
+	l.errorAt(l.tokPos, s)
+}
+
+func (l *pLexer) errorAt(pos scanner.Position, s string) {
+	l.diag(pos, com.LevelError, s)
 	if l.err == nil {
 		l.err = errors.New(fmt.Sprintf("%s:Error:%s \n", pos.String(), s))
 	}
@@ -129,5 +277,6 @@ func newLexer(r io.Reader) *pLexer {
 	p := new(pLexer)
 	p.Init(r)
 	p.print_trace = false
+	p.entries = make([]*com.PoEntry, 0)
 	return p
 }
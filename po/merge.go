@@ -0,0 +1,243 @@
+package po
+
+import (
+	"polinco/com"
+	"strings"
+)
+
+// fuzzy として訳を流用する類似度の下限. msgmerge と同じ
+const fuzzyThreshold = 0.6
+
+// Merge の結果の件数
+type MergeStats struct {
+	Matched  int // 訳をそのまま使った
+	Updated  int // Matched のうち参照やフラグなどをテンプレートに合わせて書き換えた
+	Fuzzy    int // 似た msgid の訳を fuzzy として流用した
+	Added    int // 訳の無いエントリを追加した
+	Obsolete int // #~ にした
+	Revived  int // #~ から戻した
+}
+
+// msgmerge と同様に f をテンプレートの msgid に合わせる. f を直接書き換える.
+//   - テンプレートに無くなった msgid は #~ にする
+//   - テンプレートに新しく現れた msgid は似た msgid の訳があれば fuzzy で流用し,
+//     無ければ空の訳で追加する
+//
+// 既存のエントリの順やコメントは変えないので, Write で差分を最小にできる
+func Merge(f *File, template []*com.PoEntry) MergeStats {
+	var stats MergeStats
+
+	refs := make(map[string]*com.PoEntry, len(template))
+	for _, t := range template {
+		refs[t.Key()] = t
+	}
+
+	// 既存のエントリを更新する
+	n := nplurals(f)
+	found := make(map[string]bool, len(f.Entries))
+	last := 0 // 最後の有効なエントリの次
+	for i, e := range f.Entries {
+		t, ok := refs[e.Key()]
+		if ok && !found[e.Key()] {
+			found[e.Key()] = true
+			before := copyEntry(e)
+			fuzzy := updateFromTemplate(e, t, n)
+			if e.Obsolete {
+				e.Obsolete = false
+				stats.Revived++
+			} else if fuzzy {
+				stats.Fuzzy++
+			} else {
+				stats.Matched++
+				if !sameEntry(e, &before) {
+					stats.Updated++
+				}
+			}
+		} else if !e.Obsolete {
+			e.Obsolete = true
+			e.References = nil
+			stats.Obsolete++
+		}
+		if !e.Obsolete {
+			last = i + 1
+		}
+	}
+
+	// 新しい msgid は最後の有効なエントリの後ろに追加する
+	added := make([]*com.PoEntry, 0)
+	for _, t := range template {
+		if found[t.Key()] {
+			continue
+		}
+		found[t.Key()] = true
+
+		e := newEntryFromTemplate(t, n)
+		if src := findFuzzy(f.Entries, t); src != nil {
+			e.MsgStr = src.MsgStr
+			if e.IsPlural() && src.IsPlural() {
+				copy(e.MsgStrs, src.MsgStrs)
+			} else if e.IsPlural() {
+				e.MsgStrs[0] = src.MsgStr
+			}
+			e.Flags = append([]string{"fuzzy"}, e.Flags...)
+			e.PrevContext = src.Context
+			e.PrevMsgID = src.MsgID
+			e.PrevMsgIDPlural = src.MsgIDPlural
+			stats.Fuzzy++
+		} else {
+			stats.Added++
+		}
+		added = append(added, e)
+	}
+
+	entries := make([]*com.PoEntry, 0, len(f.Entries)+len(added))
+	entries = append(entries, f.Entries[:last]...)
+	entries = append(entries, added...)
+	entries = append(entries, f.Entries[last:]...)
+	if f.Header != nil && f.headerIndex > last {
+		f.headerIndex += len(added)
+	}
+	f.Entries = entries
+	return stats
+}
+
+// 参照とフォーマットの種類はテンプレートに合わせる.
+// 翻訳者のコメントや fuzzy は残す.
+// 単数形と複数形が入れ替わったり msgid_plural が変わったりしたら,
+// 訳を移して fuzzy にする. n は nplurals. fuzzy にしたら true
+func updateFromTemplate(e, t *com.PoEntry, n int) bool {
+	e.References = copyStrings(t.References)
+	e.ExtractedComments = copyStrings(t.ExtractedComments)
+
+	flags := make([]string, 0, len(e.Flags)+len(t.Flags))
+	for _, flag := range e.Flags {
+		if !isFormatFlag(flag) {
+			flags = append(flags, flag)
+		}
+	}
+	for _, flag := range t.Flags {
+		if isFormatFlag(flag) {
+			flags = append(flags, flag)
+		}
+	}
+	if len(flags) == 0 {
+		flags = nil
+	}
+	e.Flags = flags
+
+	translated := false
+	for _, s := range e.MsgStrForms() {
+		translated = translated || s != ""
+	}
+
+	switch {
+	case t.IsPlural() && !e.IsPlural():
+		// 単数形の訳を msgstr[0] にする
+		e.MsgStrs = make([]string, max(n, 1))
+		e.MsgStrs[0] = e.MsgStr
+	case !t.IsPlural() && e.IsPlural():
+		// msgstr[0] を単数形の訳にする
+		e.MsgStr = e.MsgStrs[0]
+		e.MsgStrs = nil
+	case e.MsgIDPlural == t.MsgIDPlural:
+		return false
+	}
+
+	prevPlural := e.MsgIDPlural
+	e.MsgIDPlural = t.MsgIDPlural
+	if !translated {
+		return false
+	}
+	e.PrevContext = e.Context
+	e.PrevMsgID = e.MsgID
+	e.PrevMsgIDPlural = prevPlural
+	if !e.HasFlag("fuzzy") {
+		e.Flags = append([]string{"fuzzy"}, e.Flags...)
+	}
+	return true
+}
+
+// php-format, no-php-format など
+func isFormatFlag(flag string) bool {
+	return strings.HasSuffix(flag, "-format")
+}
+
+func newEntryFromTemplate(t *com.PoEntry, n int) *com.PoEntry {
+	e := &com.PoEntry{
+		Context:           t.Context,
+		MsgID:             t.MsgID,
+		MsgIDPlural:       t.MsgIDPlural,
+		ExtractedComments: copyStrings(t.ExtractedComments),
+		References:        copyStrings(t.References),
+	}
+	for _, flag := range t.Flags {
+		if isFormatFlag(flag) {
+			e.Flags = append(e.Flags, flag)
+		}
+	}
+	if t.IsPlural() {
+		e.MsgStrs = make([]string, n)
+	}
+	return e
+}
+
+// ヘッダの Plural-Forms の nplurals. 無ければ 2
+func nplurals(f *File) int {
+	if f.Header != nil {
+		if v, ok := f.Header.Get("Plural-Forms"); ok {
+			if n, _, ok := ParsePluralForms(v); ok {
+				return n
+			}
+		}
+	}
+	return 2
+}
+
+// t に最も似た msgid の訳のあるエントリ. 無ければ nil
+func findFuzzy(entries []*com.PoEntry, t *com.PoEntry) *com.PoEntry {
+	var best *com.PoEntry
+	bestScore := fuzzyThreshold
+	for _, e := range entries {
+		if e.MsgStr == "" || e.Context != t.Context || e.IsFuzzy() {
+			continue
+		}
+		if score := similarity(e.MsgID, t.MsgID); score >= bestScore {
+			best, bestScore = e, score
+		}
+	}
+	return best
+}
+
+// 編集距離から求めた 0 から 1 の類似度. 同じなら 1
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	n := len(ra)
+	if len(rb) > n {
+		n = len(rb)
+	}
+	if n == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(n)
+}
+
+// レーベンシュタイン距離
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,159 @@
+package po
+
+import (
+	"bytes"
+	"polinco/com"
+	"strings"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	for _, s := range []struct {
+		a, b   string
+		expect float64
+	}{
+		{"", "", 1},
+		{"abc", "abc", 1},
+		{"abcd", "abce", 0.75},
+		{"abcd", "xycd", 0.5},
+		{"あいう", "あいうえ", 0.75},
+		{"abc", "", 0},
+	} {
+		if v := similarity(s.a, s.b); v != s.expect {
+			t.Errorf("similarity(%q, %q): expect=%v actual=%v", s.a, s.b, s.expect, v)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	input := `msgid ""
+msgstr "Language: ja\n"
+
+# translator note
+#: src/a.php:1
+#, fuzzy
+msgid "Keep"
+msgstr "保持"
+
+msgid "Save the file"
+msgstr "ファイルを保存"
+
+#~ msgid "Back"
+#~ msgstr "戻る"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	template := []*com.PoEntry{
+		{MsgID: "Keep", References: []string{"src/a.php:10"}, Flags: []string{"php-format"}},
+		{MsgID: "Back"},
+		{MsgID: "Save the files"},
+		{MsgID: "Brand new"},
+	}
+	stats := Merge(f, template)
+	expectStats := MergeStats{Matched: 1, Updated: 1, Fuzzy: 1, Added: 1, Obsolete: 1, Revived: 1}
+	if stats != expectStats {
+		t.Errorf("stats: expect=%+v actual=%+v", expectStats, stats)
+	}
+
+	var buf bytes.Buffer
+	Write(&buf, f)
+	expect := `msgid ""
+msgstr "Language: ja\n"
+
+# translator note
+#: src/a.php:10
+#, fuzzy, php-format
+msgid "Keep"
+msgstr "保持"
+
+#~ msgid "Save the file"
+#~ msgstr "ファイルを保存"
+
+msgid "Back"
+msgstr "戻る"
+
+#, fuzzy
+#| msgid "Save the file"
+msgid "Save the files"
+msgstr "ファイルを保存"
+
+msgid "Brand new"
+msgstr ""
+`
+	if buf.String() != expect {
+		t.Errorf("\nexpect=%s\nactual=%s", expect, buf.String())
+	}
+
+	// もう一度マージしても変わらない
+	f2, _ := ParsePo(strings.NewReader(buf.String()))
+	Merge(f2, template)
+	var buf2 bytes.Buffer
+	Write(&buf2, f2)
+	if buf2.String() != buf.String() {
+		t.Errorf("not idempotent:\n%s", buf2.String())
+	}
+}
+
+// 単数形と複数形が入れ替わったエントリは訳を移して fuzzy にする
+func TestMergePlural(t *testing.T) {
+	input := `msgid ""
+msgstr ""
+"Language: ja\n"
+"Plural-Forms: nplurals=1; plural=0;\n"
+
+msgid "{0} file"
+msgstr "{0} ファイル"
+
+msgid "{0} item"
+msgid_plural "{0} items"
+msgstr[0] "{0} 項目"
+
+msgid "Untranslated"
+msgstr ""
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	template := []*com.PoEntry{
+		{MsgID: "{0} file", MsgIDPlural: "{0} files", MsgStrs: []string{"", ""}},
+		{MsgID: "{0} item"},
+		{MsgID: "Untranslated", MsgIDPlural: "Untranslated many", MsgStrs: []string{"", ""}},
+	}
+	stats := Merge(f, template)
+	expectStats := MergeStats{Matched: 1, Updated: 1, Fuzzy: 2}
+	if stats != expectStats {
+		t.Errorf("stats: expect=%+v actual=%+v", expectStats, stats)
+	}
+
+	var buf bytes.Buffer
+	Write(&buf, f)
+	expect := `msgid ""
+msgstr ""
+"Language: ja\n"
+"Plural-Forms: nplurals=1; plural=0;\n"
+
+#, fuzzy
+#| msgid "{0} file"
+msgid "{0} file"
+msgid_plural "{0} files"
+msgstr[0] "{0} ファイル"
+
+#, fuzzy
+#| msgid "{0} item"
+#| msgid_plural "{0} items"
+msgid "{0} item"
+msgstr "{0} 項目"
+
+msgid "Untranslated"
+msgid_plural "Untranslated many"
+msgstr[0] ""
+`
+	if buf.String() != expect {
+		t.Errorf("\nexpect=%s\nactual=%s", expect, buf.String())
+	}
+}
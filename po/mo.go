@@ -0,0 +1,248 @@
+package po
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"polinco/com"
+	"sort"
+	"strings"
+	"text/scanner"
+)
+
+// /////////////////////////////////////////////////////////////
+// GNU MO ファイル
+// https://www.gnu.org/software/gettext/manual/html_node/MO-Files.html
+// /////////////////////////////////////////////////////////////
+const moMagic = 0x950412de
+
+// msgctxt と msgid の区切り
+const moContextSep = "\x04"
+
+// msgid と msgid_plural, msgstr[N] の区切り
+const moPluralSep = "\x00"
+
+// MO ファイルを読む. リトルエンディアンとビッグエンディアンの両方に対応する.
+// 位置情報は Pos.Offset に msgid の文字列のオフセットを設定する
+func ReadMo(r io.Reader) (*File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 28 {
+		return nil, errors.New("mo: file too short")
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(data) == moMagic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(data) == moMagic:
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("mo: invalid magic number")
+	}
+
+	revision := order.Uint32(data[4:])
+	if major := revision >> 16; major > 1 {
+		return nil, fmt.Errorf("mo: unsupported revision %d.%d", major, revision&0xffff)
+	}
+	n := order.Uint32(data[8:])
+	origTable := order.Uint32(data[12:])
+	transTable := order.Uint32(data[16:])
+
+	// i 番目の文字列
+	str := func(table uint32, i uint32) (string, uint32, error) {
+		off := uint64(table) + uint64(i)*8
+		if off+8 > uint64(len(data)) {
+			return "", 0, fmt.Errorf("mo: string table out of range: %d", off)
+		}
+		length := order.Uint32(data[off:])
+		offset := order.Uint32(data[off+4:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return "", 0, fmt.Errorf("mo: string out of range: offset=%d, length=%d", offset, length)
+		}
+		return string(data[offset : offset+length]), offset, nil
+	}
+
+	entries := make([]*com.PoEntry, 0, n)
+	for i := uint32(0); i < n; i++ {
+		key, offset, err := str(origTable, i)
+		if err != nil {
+			return nil, err
+		}
+		value, _, err := str(transTable, i)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, newMoEntry(key, value, offset))
+	}
+
+	f := &File{Entries: make([]*com.PoEntry, 0, len(entries))}
+	for _, entry := range entries {
+		if f.Header == nil && isHeaderEntry(entry) {
+			f.Header = newHeader(entry)
+		} else {
+			f.Entries = append(f.Entries, entry)
+		}
+	}
+	return f, nil
+}
+
+func newMoEntry(key, value string, offset uint32) *com.PoEntry {
+	e := &com.PoEntry{Pos: scanner.Position{Offset: int(offset)}}
+	if n := strings.Index(key, moContextSep); n >= 0 {
+		e.Context = key[:n]
+		key = key[n+len(moContextSep):]
+	}
+
+	if n := strings.Index(key, moPluralSep); n >= 0 {
+		e.MsgID = key[:n]
+		e.MsgIDPlural = key[n+len(moPluralSep):]
+		e.MsgStrs = strings.Split(value, moPluralSep)
+		e.MsgStr = e.MsgStrs[0]
+	} else {
+		e.MsgID = key
+		e.MsgStr = value
+	}
+	return e
+}
+
+// /////////////////////////////////////////////////////////////
+// MO ファイルの書き出し (msgfmt 相当)
+// /////////////////////////////////////////////////////////////
+
+// MO ファイルに含めるエントリか.
+// msgfmt と同様に fuzzy, 廃止, 未翻訳のエントリは含めない
+func IsMoEntry(e *com.PoEntry) bool {
+	if e.Obsolete || e.IsFuzzy() {
+		return false
+	}
+	for _, s := range e.MsgStrForms() {
+		if s == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func moKey(e *com.PoEntry) string {
+	key := e.MsgID
+	if e.Context != "" {
+		key = e.Context + moContextSep + key
+	}
+	if e.IsPlural() {
+		key += moPluralSep + e.MsgIDPlural
+	}
+	return key
+}
+
+// f をリトルエンディアンの MO ファイルとして書き出す. ハッシュ表も作る
+func WriteMo(w io.Writer, f *File) error {
+	type pair struct{ key, value string }
+	pairs := make([]pair, 0, len(f.Entries)+1)
+	if f.Header != nil {
+		pairs = append(pairs, pair{"", f.Header.Entry.MsgStr})
+	}
+	for _, e := range f.Entries {
+		if IsMoEntry(e) {
+			pairs = append(pairs, pair{moKey(e), strings.Join(e.MsgStrForms(), moPluralSep)})
+		}
+	}
+	// gettext は二分探索するのでキーの順に並べる
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].key < pairs[j].key
+	})
+	for i := 1; i < len(pairs); i++ {
+		if pairs[i-1].key == pairs[i].key {
+			return fmt.Errorf("mo: duplicate msgid: %q", pairs[i].key)
+		}
+	}
+
+	n := uint32(len(pairs))
+	hashSize := moHashSize(n)
+	origTable := uint32(28)
+	transTable := origTable + n*8
+	hashTable := transTable + n*8
+	offset := hashTable + hashSize*4
+
+	var buf bytes.Buffer
+	order := binary.LittleEndian
+	for _, v := range []uint32{moMagic, 0, n, origTable, transTable, hashSize, hashTable} {
+		binary.Write(&buf, order, v)
+	}
+
+	var body bytes.Buffer
+	for _, p := range pairs {
+		binary.Write(&buf, order, uint32(len(p.key)))
+		binary.Write(&buf, order, offset+uint32(body.Len()))
+		body.WriteString(p.key + "\x00")
+	}
+	for _, p := range pairs {
+		binary.Write(&buf, order, uint32(len(p.value)))
+		binary.Write(&buf, order, offset+uint32(body.Len()))
+		body.WriteString(p.value + "\x00")
+	}
+
+	hash := make([]uint32, hashSize)
+	for i, p := range pairs {
+		hval := moHashString(p.key)
+		idx := hval % hashSize
+		incr := 1 + hval%(hashSize-2)
+		for hash[idx] != 0 {
+			if idx >= hashSize-incr {
+				idx -= hashSize - incr
+			} else {
+				idx += incr
+			}
+		}
+		hash[idx] = uint32(i) + 1
+	}
+	for _, v := range hash {
+		binary.Write(&buf, order, v)
+	}
+
+	buf.Write(body.Bytes())
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// gettext の hash_string. NUL までをハッシュする
+func moHashString(s string) uint32 {
+	var hval uint32
+	for i := 0; i < len(s) && s[i] != 0; i++ {
+		hval <<= 4
+		hval += uint32(s[i])
+		if g := hval & (0xf << 28); g != 0 {
+			hval ^= g >> 24
+			hval ^= g
+		}
+	}
+	return hval
+}
+
+// msgfmt と同じく, 要素数の 4/3 以上の素数
+func moHashSize(n uint32) uint32 {
+	size := n * 4 / 3
+	if size <= 2 {
+		return 3
+	}
+	for !isPrime(size) {
+		size++
+	}
+	return size
+}
+
+func isPrime(n uint32) bool {
+	if n < 2 {
+		return false
+	}
+	for d := uint32(2); d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package po
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// ハッシュ表なしの MO ファイルを作る
+func buildMo(order binary.ByteOrder, pairs [][2]string) []byte {
+	n := uint32(len(pairs))
+	origTable := uint32(28)
+	transTable := origTable + n*8
+	offset := transTable + n*8
+
+	var head, body bytes.Buffer
+	for _, v := range []uint32{moMagic, 0, n, origTable, transTable, 0, offset} {
+		binary.Write(&head, order, v)
+	}
+
+	var orig, trans bytes.Buffer
+	for _, p := range pairs {
+		binary.Write(&orig, order, uint32(len(p[0])))
+		binary.Write(&orig, order, offset+uint32(body.Len()))
+		body.WriteString(p[0] + "\x00")
+	}
+	for _, p := range pairs {
+		binary.Write(&trans, order, uint32(len(p[1])))
+		binary.Write(&trans, order, offset+uint32(body.Len()))
+		body.WriteString(p[1] + "\x00")
+	}
+	return append(append(append(head.Bytes(), orig.Bytes()...), trans.Bytes()...), body.Bytes()...)
+}
+
+func TestReadMo(t *testing.T) {
+	pairs := [][2]string{
+		{"", "Language: ja\nPlural-Forms: nplurals=1; plural=0;\n"},
+		{"Hello", "こんにちは"},
+		{"status\x04Open", "公開中"},
+		{"{0} file\x00{0} files", "{0} ファイル"},
+		{"ctx\x04a\x00as", "A\x00As"},
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		f, err := ReadMo(bytes.NewReader(buildMo(order, pairs)))
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", order, err)
+			continue
+		}
+
+		if f.Header == nil || f.Header.Language() != "ja" {
+			t.Errorf("%v: unexpected header: %v", order, f.Header)
+		}
+		if len(f.Entries) != 4 {
+			t.Errorf("%v: unexpected entries: %v", order, f.Entries)
+			continue
+		}
+
+		for i, s := range []struct {
+			context  string
+			msgid    string
+			plural   string
+			msgstrs  []string
+			isPlural bool
+		}{
+			{"", "Hello", "", []string{"こんにちは"}, false},
+			{"status", "Open", "", []string{"公開中"}, false},
+			{"", "{0} file", "{0} files", []string{"{0} ファイル"}, true},
+			{"ctx", "a", "as", []string{"A", "As"}, true},
+		} {
+			e := f.Entries[i]
+			if e.Context != s.context || e.MsgID != s.msgid || e.MsgIDPlural != s.plural ||
+				e.IsPlural() != s.isPlural || strings.Join(e.MsgStrForms(), "|") != strings.Join(s.msgstrs, "|") ||
+				e.MsgStr != s.msgstrs[0] {
+				t.Errorf("%v: %d: expect=%v\nactual=%v", order, i, s, *e)
+			}
+		}
+	}
+
+	for _, data := range [][]byte{
+		{},
+		[]byte("not a mo file, not a mo file"),
+		buildMo(binary.LittleEndian, pairs)[:40],
+	} {
+		if _, err := ReadMo(bytes.NewReader(data)); err == nil {
+			t.Errorf("expect error: %q", data)
+		}
+	}
+}
+
+// gettext と同じ手順でハッシュ表から探す
+func lookupMoHash(data []byte, key string) (string, bool) {
+	order := binary.LittleEndian
+	transTable := order.Uint32(data[16:])
+	hashSize := order.Uint32(data[20:])
+	hashTable := order.Uint32(data[24:])
+	origTable := order.Uint32(data[12:])
+
+	hval := moHashString(key)
+	idx := hval % hashSize
+	incr := 1 + hval%(hashSize-2)
+	for {
+		i := order.Uint32(data[hashTable+idx*4:])
+		if i == 0 {
+			return "", false
+		}
+		i--
+		length := order.Uint32(data[origTable+i*8:])
+		offset := order.Uint32(data[origTable+i*8+4:])
+		if string(data[offset:offset+length]) == key {
+			length = order.Uint32(data[transTable+i*8:])
+			offset = order.Uint32(data[transTable+i*8+4:])
+			return string(data[offset : offset+length]), true
+		}
+		if idx >= hashSize-incr {
+			idx -= hashSize - incr
+		} else {
+			idx += incr
+		}
+	}
+}
+
+func TestWriteMo(t *testing.T) {
+	input := `msgid ""
+msgstr ""
+"Language: ja\n"
+"Plural-Forms: nplurals=1; plural=0;\n"
+
+msgid "Hello"
+msgstr "こんにちは"
+
+msgctxt "status"
+msgid "Open"
+msgstr "公開中"
+
+msgid "{0} file"
+msgid_plural "{0} files"
+msgstr[0] "{0} ファイル"
+
+#, fuzzy
+msgid "fuzzy"
+msgstr "あいまい"
+
+msgid "untranslated"
+msgstr ""
+
+#~ msgid "obsolete"
+#~ msgstr "廃止"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMo(&buf, f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mo, err := ReadMo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mo.Header == nil || mo.Header.Entry.MsgStr != f.Header.Entry.MsgStr {
+		t.Errorf("unexpected header: %v", mo.Header)
+	}
+
+	keys := make([]string, 0)
+	for _, e := range mo.Entries {
+		keys = append(keys, e.Key())
+	}
+	// キーの順に並ぶ
+	if strings.Join(keys, ",") != "Hello,status\x04Open,{0} file" {
+		t.Errorf("unexpected entries: %q", keys)
+	}
+
+	for _, s := range []struct {
+		key    string
+		expect string
+		ok     bool
+	}{
+		{"", f.Header.Entry.MsgStr, true},
+		{"Hello", "こんにちは", true},
+		{"status\x04Open", "公開中", true},
+		{"{0} file\x00{0} files", "{0} ファイル", true},
+		{"Open", "", false},
+		{"fuzzy", "", false},
+		{"untranslated", "", false},
+		{"obsolete", "", false},
+	} {
+		v, ok := lookupMoHash(buf.Bytes(), s.key)
+		if ok != s.ok || v != s.expect {
+			t.Errorf("key=%q: expect=%q %v, actual=%q %v", s.key, s.expect, s.ok, v, ok)
+		}
+	}
+}
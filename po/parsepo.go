@@ -8,28 +8,45 @@ import __yyfmt__ "fmt"
 //line po/parsepo.y:2
 
 import (
-	//	"fmt"
+	"fmt"
 	"polinco/com"
 )
 
-var poEntries []*com.PoEntry
+// コメント, msgctxt, msgid のうち最初のものの位置
+func entryStart(nodes ...pNode) int {
+	for _, n := range nodes {
+		if n.start >= 0 {
+			return n.start
+		}
+	}
+	panic("bug!")
+}
 
-//line po/parsepo.y:12
+//line po/parsepo.y:20
 type yySymType struct {
 	yys  int
 	node pNode
+	strs []string
 }
 
-const MSGID = 57346
-const MSGSTR = 57347
-const STRING = 57348
+const COMMENT = 57346
+const MSGCTXT = 57347
+const MSGID = 57348
+const MSGID_PLURAL = 57349
+const MSGSTR = 57350
+const MSGSTR_N = 57351
+const STRING = 57352
 
 var yyToknames = [...]string{
 	"$end",
 	"error",
 	"$unk",
+	"COMMENT",
+	"MSGCTXT",
 	"MSGID",
+	"MSGID_PLURAL",
 	"MSGSTR",
+	"MSGSTR_N",
 	"STRING",
 }
 
@@ -39,56 +56,75 @@ const yyEofCode = 1
 const yyErrCode = 2
 const yyInitialStackSize = 16
 
-//line po/parsepo.y:43
+//line po/parsepo.y:128
 
 //line yacctab:1
-var yyExca = [...]int{
+var yyExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
+	-1, 2,
+	1, 8,
+	4, 8,
+	5, 8,
+	6, 8,
+	-2, 0,
+	-1, 3,
+	6, 10,
+	-2, 1,
 }
 
 const yyPrivate = 57344
 
-const yyLast = 10
+const yyLast = 25
 
-var yyAct = [...]int{
-	5, 7, 8, 8, 6, 4, 3, 2, 9, 1,
+var yyAct = [...]int8{
+	11, 16, 15, 14, 14, 20, 14, 12, 21, 10,
+	13, 7, 8, 5, 19, 6, 17, 18, 9, 4,
+	3, 22, 23, 2, 1,
 }
 
-var yyPact = [...]int{
-	-1000, -1000, 1, -1000, -2, -4, -1000, -2, -1000, -3,
+var yyPact = [...]int16{
+	-32768, -32768, 11, 7, -32768, -32768, 3, -32768, -3, -3,
+	-32768, -7, -32768, -6, -32768, -3, -3, -7, -4, -1,
+	-3, -3, -7, -7,
 }
 
-var yyPgo = [...]int{
-	0, 9, 7, 6, 0,
+var yyPgo = [...]int8{
+	0, 24, 23, 20, 19, 18, 15, 0, 14,
 }
 
-var yyR1 = [...]int{
-	0, 1, 2, 2, 3, 4, 4,
+var yyR1 = [...]int8{
+	0, 1, 2, 2, 2, 5, 4, 4, 3, 3,
+	6, 6, 8, 8, 7, 7,
 }
 
-var yyR2 = [...]int{
-	0, 1, 0, 2, 4, 1, 2,
+var yyR2 = [...]int8{
+	0, 2, 0, 2, 2, 1, 6, 7, 0, 2,
+	0, 2, 2, 3, 1, 2,
 }
 
-var yyChk = [...]int{
-	-1000, -1, -2, -3, 4, -4, 6, 5, 6, -4,
+var yyChk = [...]int16{
+	-32768, -1, -2, -3, -4, 2, -6, 4, 5, -5,
+	6, -7, 10, -7, 10, 8, 7, -7, -7, -8,
+	9, 9, -7, -7,
 }
 
-var yyDef = [...]int{
-	2, -2, 1, 3, 0, 0, 5, 0, 6, 4,
+var yyDef = [...]int8{
+	2, -2, -2, -2, 3, 4, 0, 9, 0, 0,
+	5, 11, 14, 0, 15, 0, 0, 6, 0, 7,
+	0, 0, 12, 13,
 }
 
-var yyTok1 = [...]int{
+var yyTok1 = [...]int8{
 	1,
 }
 
-var yyTok2 = [...]int{
-	2, 3, 4, 5, 6,
+var yyTok2 = [...]int8{
+	2, 3, 4, 5, 6, 7, 8, 9, 10,
 }
 
-var yyTok3 = [...]int{
+var yyTok3 = [...]int8{
 	0,
 }
 
@@ -131,7 +167,7 @@ func yyNewParser() yyParser {
 	return &yyParserImpl{}
 }
 
-const yyFlag = -1000
+const yyFlag = -32768
 
 func yyTokname(c int) string {
 	if c >= 1 && c-1 < len(yyToknames) {
@@ -170,9 +206,9 @@ func yyErrorMessage(state, lookAhead int) string {
 	expected := make([]int, 0, 4)
 
 	// Look for shiftable tokens.
-	base := yyPact[state]
+	base := int(yyPact[state])
 	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
-		if n := base + tok; n >= 0 && n < yyLast && yyChk[yyAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < yyLast && int(yyChk[int(yyAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -182,13 +218,13 @@ func yyErrorMessage(state, lookAhead int) string {
 
 	if yyDef[state] == -2 {
 		i := 0
-		for yyExca[i] != -1 || yyExca[i+1] != state {
+		for yyExca[i] != -1 || int(yyExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; yyExca[i] >= 0; i += 2 {
-			tok := yyExca[i]
+			tok := int(yyExca[i])
 			if tok < TOKSTART || yyExca[i+1] == 0 {
 				continue
 			}
@@ -219,30 +255,30 @@ func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = yyTok1[0]
+		token = int(yyTok1[0])
 		goto out
 	}
 	if char < len(yyTok1) {
-		token = yyTok1[char]
+		token = int(yyTok1[char])
 		goto out
 	}
 	if char >= yyPrivate {
 		if char < yyPrivate+len(yyTok2) {
-			token = yyTok2[char-yyPrivate]
+			token = int(yyTok2[char-yyPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(yyTok3); i += 2 {
-		token = yyTok3[i+0]
+		token = int(yyTok3[i+0])
 		if token == char {
-			token = yyTok3[i+1]
+			token = int(yyTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = yyTok2[1] /* unknown char */
+		token = int(yyTok2[1]) /* unknown char */
 	}
 	if yyDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
@@ -297,7 +333,7 @@ yystack:
 	yyS[yyp].yys = yystate
 
 yynewstate:
-	yyn = yyPact[yystate]
+	yyn = int(yyPact[yystate])
 	if yyn <= yyFlag {
 		goto yydefault /* simple state */
 	}
@@ -308,8 +344,8 @@ yynewstate:
 	if yyn < 0 || yyn >= yyLast {
 		goto yydefault
 	}
-	yyn = yyAct[yyn]
-	if yyChk[yyn] == yytoken { /* valid shift */
+	yyn = int(yyAct[yyn])
+	if int(yyChk[yyn]) == yytoken { /* valid shift */
 		yyrcvr.char = -1
 		yytoken = -1
 		yyVAL = yyrcvr.lval
@@ -322,7 +358,7 @@ yynewstate:
 
 yydefault:
 	/* default state action */
-	yyn = yyDef[yystate]
+	yyn = int(yyDef[yystate])
 	if yyn == -2 {
 		if yyrcvr.char < 0 {
 			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
@@ -331,18 +367,18 @@ yydefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if yyExca[xi+0] == -1 && yyExca[xi+1] == yystate {
+			if yyExca[xi+0] == -1 && int(yyExca[xi+1]) == yystate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			yyn = yyExca[xi+0]
+			yyn = int(yyExca[xi+0])
 			if yyn < 0 || yyn == yytoken {
 				break
 			}
 		}
-		yyn = yyExca[xi+1]
+		yyn = int(yyExca[xi+1])
 		if yyn < 0 {
 			goto ret0
 		}
@@ -364,10 +400,10 @@ yydefault:
 
 			/* find a state where "error" is a legal shift action */
 			for yyp >= 0 {
-				yyn = yyPact[yyS[yyp].yys] + yyErrCode
+				yyn = int(yyPact[yyS[yyp].yys]) + yyErrCode
 				if yyn >= 0 && yyn < yyLast {
-					yystate = yyAct[yyn] /* simulate a shift of "error" */
-					if yyChk[yystate] == yyErrCode {
+					yystate = int(yyAct[yyn]) /* simulate a shift of "error" */
+					if int(yyChk[yystate]) == yyErrCode {
 						goto yystack
 					}
 				}
@@ -403,7 +439,7 @@ yydefault:
 	yypt := yyp
 	_ = yypt // guard against "declared and not used"
 
-	yyp -= yyR2[yyn]
+	yyp -= int(yyR2[yyn])
 	// yyp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if yyp+1 >= len(yyS) {
@@ -414,37 +450,119 @@ yydefault:
 	yyVAL = yyS[yyp+1]
 
 	/* consult goto table to find next state */
-	yyn = yyR1[yyn]
-	yyg := yyPgo[yyn]
+	yyn = int(yyR1[yyn])
+	yyg := int(yyPgo[yyn])
 	yyj := yyg + yyS[yyp].yys + 1
 
 	if yyj >= yyLast {
-		yystate = yyAct[yyg]
+		yystate = int(yyAct[yyg])
 	} else {
-		yystate = yyAct[yyj]
-		if yyChk[yystate] != -yyn {
-			yystate = yyAct[yyg]
+		yystate = int(yyAct[yyj])
+		if int(yyChk[yystate]) != -yyn {
+			yystate = int(yyAct[yyg])
 		}
 	}
 	// dummy call; replaced with literal code
 	switch yynt {
 
 	case 1:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line po/parsepo.y:21
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line po/parsepo.y:30
 		{
 		}
 	case 4:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line po/parsepo.y:30
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line po/parsepo.y:35
 		{
-			poEntries = append(poEntries, &com.PoEntry{MsgID: yyDollar[2].node.str, MsgStr: yyDollar[4].node.str, Pos: yyDollar[1].node.pos})
+			/* 次の msgid まで読み飛ばして続ける */
+		}
+	case 5:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line po/parsepo.y:50
+		{
+			Errflag = 0
 		}
 	case 6:
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line po/parsepo.y:57
+		{
+			pos := yyDollar[3].node.pos
+			if yyDollar[2].node.cmd == MSGCTXT {
+				pos = yyDollar[2].node.pos
+			}
+			e := &com.PoEntry{Context: yyDollar[2].node.str, MsgID: yyDollar[4].node.str, MsgStr: yyDollar[6].node.str, Pos: pos, Obsolete: yyDollar[3].node.obsolete}
+			setComments(e, yyDollar[1].strs)
+			yylex.(*pLexer).addEntry(e, entryStart(yyDollar[1].node, yyDollar[2].node, yyDollar[3].node), yyDollar[6].node.end)
+		}
+	case 7:
+		yyDollar = yyS[yypt-7 : yypt+1]
+//line po/parsepo.y:66
+		{
+			pos := yyDollar[3].node.pos
+			if yyDollar[2].node.cmd == MSGCTXT {
+				pos = yyDollar[2].node.pos
+			}
+			e := &com.PoEntry{Context: yyDollar[2].node.str, MsgID: yyDollar[4].node.str, MsgIDPlural: yyDollar[6].node.str, MsgStr: yyDollar[7].strs[0], MsgStrs: yyDollar[7].strs, Pos: pos, Obsolete: yyDollar[3].node.obsolete}
+			setComments(e, yyDollar[1].strs)
+			yylex.(*pLexer).addEntry(e, entryStart(yyDollar[1].node, yyDollar[2].node, yyDollar[3].node), yyDollar[7].node.end)
+		}
+	case 8:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line po/parsepo.y:78
+		{
+			yyVAL.strs = nil
+			yyVAL.node = pNode{start: -1}
+		}
+	case 9:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line po/parsepo.y:82
+		{
+			yyVAL.strs = append(yyDollar[1].strs, yyDollar[2].node.str)
+			if yyDollar[1].node.start < 0 {
+				yyVAL.node.start = yyDollar[2].node.start
+			}
+		}
+	case 10:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line po/parsepo.y:91
+		{
+			yyVAL.node = pNode{start: -1}
+		}
+	case 11:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line po/parsepo.y:94
+		{
+			yyVAL.node = yyDollar[2].node
+			yyVAL.node.cmd = MSGCTXT
+			yyVAL.node.pos = yyDollar[1].node.pos
+			yyVAL.node.start = yyDollar[1].node.start
+		}
+	case 12:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line po/parsepo.y:104
+		{
+			if yyDollar[1].node.extra != 0 {
+				yylex.(*pLexer).errorAt(yyDollar[1].node.pos, fmt.Sprintf("unexpected msgstr[%d], expected msgstr[0]", yyDollar[1].node.extra))
+			}
+			yyVAL.strs = []string{yyDollar[2].node.str}
+			yyVAL.node.end = yyDollar[2].node.end
+		}
+	case 13:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line po/parsepo.y:111
+		{
+			if yyDollar[2].node.extra != len(yyDollar[1].strs) {
+				yylex.(*pLexer).errorAt(yyDollar[2].node.pos, fmt.Sprintf("unexpected msgstr[%d], expected msgstr[%d]", yyDollar[2].node.extra, len(yyDollar[1].strs)))
+			}
+			yyVAL.strs = append(yyDollar[1].strs, yyDollar[3].node.str)
+			yyVAL.node.end = yyDollar[3].node.end
+		}
+	case 15:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line po/parsepo.y:38
+//line po/parsepo.y:122
 		{
 			yyVAL.node.str = yyDollar[1].node.str + yyDollar[2].node.str
+			yyVAL.node.end = yyDollar[2].node.end
 		}
 	}
 	goto yystack /* stack new state and value */
@@ -2,41 +2,126 @@
 package po
 
 import (
-//	"fmt"
-	"polint/com"
+	"fmt"
+	"polinco/com"
 )
 
-var poEntries []*com.PoEntry
+// コメント, msgctxt, msgid のうち最初のものの位置
+func entryStart(nodes ...pNode) int {
+	for _, n := range nodes {
+		if n.start >= 0 {
+			return n.start
+		}
+	}
+	panic("bug!")
+}
 %}
 
 %union{
 	node pNode
+	strs []string
 }
 
-%token MSGID MSGSTR STRING
+%token COMMENT MSGCTXT MSGID MSGID_PLURAL MSGSTR MSGSTR_N STRING
 
 %%
 
 pofile
-	  : entries {}
+	  : entries comments {}
 	  ;
 
 entries:
 	   | entries entry
+	   | entries error {
+		/* 次の msgid まで読み飛ばして続ける */
+	   }
 	   ;
 
+/*
+ * msgid を読んだらエラー回復を終える.
+ * goyacc は回復中 (3 トークンを読むまで) のエラーを報告しないので,
+ * 壊れたエントリが続くと 2 つ目以降が報告されずに消える.
+ *
+ * goyacc には yyerrok が無いため, yyParse のローカル変数 Errflag を直接戻す.
+ * これは golang.org/x/tools/cmd/goyacc v0.50.0 の生成コードに依存している.
+ * goyacc を更新したら TestParsePoRecoveryConsecutive で確かめること
+ */
+msgid
+	: MSGID {
+		Errflag = 0
+	}
+	;
+
 
 entry:
-	 MSGID strings MSGSTR strings {
-	 	poEntries = append(poEntries, &com.PoEntry{MsgID: $2.node.str, MsgStr: $4.node.str, Pos: $1.node.pos})
+	 comments msgctxt msgid strings MSGSTR strings {
+		pos := $3.node.pos
+		if $2.node.cmd == MSGCTXT {
+			pos = $2.node.pos
+		}
+		e := &com.PoEntry{Context: $2.node.str, MsgID: $4.node.str, MsgStr: $6.node.str, Pos: pos, Obsolete: $3.node.obsolete}
+		setComments(e, $1.strs)
+		yylex.(*pLexer).addEntry(e, entryStart($1.node, $2.node, $3.node), $6.node.end)
 	}
+	| comments msgctxt msgid strings MSGID_PLURAL strings msgstrs {
+		pos := $3.node.pos
+		if $2.node.cmd == MSGCTXT {
+			pos = $2.node.pos
+		}
+		e := &com.PoEntry{Context: $2.node.str, MsgID: $4.node.str, MsgIDPlural: $6.node.str, MsgStr: $7.strs[0], MsgStrs: $7.strs, Pos: pos, Obsolete: $3.node.obsolete}
+		setComments(e, $1.strs)
+		yylex.(*pLexer).addEntry(e, entryStart($1.node, $2.node, $3.node), $7.node.end)
+	}
+	;
 
+comments
+	: {
+		$$.strs = nil
+		$$.node = pNode{start: -1}
+	}
+	| comments COMMENT {
+		$$.strs = append($1.strs, $2.node.str)
+		if $1.node.start < 0 {
+			$$.node.start = $2.node.start
+		}
+	}
+	;
+
+msgctxt
+	: {
+		$$.node = pNode{start: -1}
+	}
+	| MSGCTXT strings {
+		$$.node = $2.node
+		$$.node.cmd = MSGCTXT
+		$$.node.pos = $1.node.pos
+		$$.node.start = $1.node.start
+	}
+	;
 
+/* msgstr[0] "..." msgstr[1] "..." */
+msgstrs
+	: MSGSTR_N strings {
+		if $1.node.extra != 0 {
+			yylex.(*pLexer).errorAt($1.node.pos, fmt.Sprintf("unexpected msgstr[%d], expected msgstr[0]", $1.node.extra))
+		}
+		$$.strs = []string{$2.node.str}
+		$$.node.end = $2.node.end
+	}
+	| msgstrs MSGSTR_N strings {
+		if $2.node.extra != len($1.strs) {
+			yylex.(*pLexer).errorAt($2.node.pos, fmt.Sprintf("unexpected msgstr[%d], expected msgstr[%d]", $2.node.extra, len($1.strs)))
+		}
+		$$.strs = append($1.strs, $3.node.str)
+		$$.node.end = $3.node.end
+	}
+	;
 
 strings
 	: STRING
 	| strings STRING {
 		$$.node.str = $1.node.str + $2.node.str
+		$$.node.end = $2.node.end
 	}
 	;
 
@@ -0,0 +1,335 @@
+package po
+
+import (
+	"errors"
+	"fmt"
+	"polinco/com"
+	"strings"
+)
+
+// /////////////////////////////////////////////////////////////
+// Plural-Forms の plural=EXPR を評価する.
+// C の式のうち gettext が許すもの (?: || && == != < > <= >= + - * / % ! 括弧 n 数値)
+// /////////////////////////////////////////////////////////////
+type PluralExpr struct {
+	root pluralNode
+	src  string
+}
+
+type pluralNode interface {
+	eval(n uint64) uint64
+}
+
+type pluralVar struct{}
+type pluralNum struct{ v uint64 }
+type pluralNot struct{ x pluralNode }
+type pluralCond struct{ cond, then, els pluralNode }
+type pluralBinOp struct {
+	op   string
+	x, y pluralNode
+}
+
+func (p pluralVar) eval(n uint64) uint64 { return n }
+func (p pluralNum) eval(n uint64) uint64 { return p.v }
+func (p pluralNot) eval(n uint64) uint64 { return b2u(p.x.eval(n) == 0) }
+func (p pluralCond) eval(n uint64) uint64 {
+	if p.cond.eval(n) != 0 {
+		return p.then.eval(n)
+	}
+	return p.els.eval(n)
+}
+
+func (p pluralBinOp) eval(n uint64) uint64 {
+	x := p.x.eval(n)
+	// 短絡評価
+	switch p.op {
+	case "||":
+		return b2u(x != 0 || p.y.eval(n) != 0)
+	case "&&":
+		return b2u(x != 0 && p.y.eval(n) != 0)
+	}
+
+	y := p.y.eval(n)
+	switch p.op {
+	case "==":
+		return b2u(x == y)
+	case "!=":
+		return b2u(x != y)
+	case "<":
+		return b2u(x < y)
+	case ">":
+		return b2u(x > y)
+	case "<=":
+		return b2u(x <= y)
+	case ">=":
+		return b2u(x >= y)
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			return 0
+		}
+		return x / y
+	case "%":
+		if y == 0 {
+			return 0
+		}
+		return x % y
+	}
+	panic("unknown operator: " + p.op)
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// n に対応する msgstr[N] の N
+func (e *PluralExpr) Eval(n uint64) uint64 {
+	return e.root.eval(n)
+}
+
+func (e *PluralExpr) String() string {
+	return e.src
+}
+
+// n = 0..max で各 msgstr[N] が選ばれた回数
+func (e *PluralExpr) Histogram(max uint64) map[uint64]int {
+	ret := make(map[uint64]int)
+	for n := uint64(0); n <= max; n++ {
+		ret[e.Eval(n)]++
+	}
+	return ret
+}
+
+// n = 0..max で同じ値を返すか. 異なる場合は最初の n を返す
+func (e *PluralExpr) Equal(f *PluralExpr, max uint64) (uint64, bool) {
+	for n := uint64(0); n <= max; n++ {
+		if e.Eval(n) != f.Eval(n) {
+			return n, false
+		}
+	}
+	return 0, true
+}
+
+// /////////////////////////////////////////////////////////////
+// 再帰下降構文解析
+// /////////////////////////////////////////////////////////////
+type pluralParser struct {
+	com.Lexer
+	src []rune
+	pos int
+}
+
+func ParsePluralExpr(s string) (*PluralExpr, error) {
+	p := &pluralParser{src: []rune(s)}
+	root, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.error("unexpected token")
+	}
+	return &PluralExpr{root: root, src: strings.TrimSpace(s)}, nil
+}
+
+func (p *pluralParser) error(msg string) error {
+	return errors.New(fmt.Sprintf("plural: %s at %d: %s", msg, p.pos+1, string(p.src)))
+}
+
+func (p *pluralParser) skipSpace() {
+	for p.pos < len(p.src) && p.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// 演算子 op を読めたら true
+func (p *pluralParser) accept(op string) bool {
+	p.skipSpace()
+	rs := []rune(op)
+	if p.pos+len(rs) > len(p.src) || string(p.src[p.pos:p.pos+len(rs)]) != op {
+		return false
+	}
+	// < と <= など, 長い方の演算子の一部を読まないように
+	if len(rs) == 1 && p.pos+1 < len(p.src) {
+		next := p.src[p.pos+1]
+		if (op == "<" || op == ">" || op == "!") && next == '=' ||
+			op == "|" && next == '|' || op == "&" && next == '&' {
+			return false
+		}
+	}
+	p.pos += len(rs)
+	return true
+}
+
+// cond: or ? cond : cond
+func (p *pluralParser) parseCond() (pluralNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	then, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(":") {
+		return nil, p.error("missing ':'")
+	}
+	els, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	return pluralCond{cond: cond, then: then, els: els}, nil
+}
+
+// 優先順位の低い順
+var pluralBinOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *pluralParser) parseBinary(level int) (pluralNode, error) {
+	if level >= len(pluralBinOps) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range pluralBinOps[level] {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = pluralBinOp{op: op, x: x, y: y}
+	}
+}
+
+func (p *pluralParser) parseUnary() (pluralNode, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return pluralNot{x: x}, nil
+	}
+
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.error("unexpected end")
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		x, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.error("missing ')'")
+		}
+		return x, nil
+	case c == 'n':
+		p.pos++
+		if p.pos < len(p.src) && p.IsLetter(p.src[p.pos]) {
+			return nil, p.error("unknown identifier")
+		}
+		return pluralVar{}, nil
+	case p.IsDigit(c):
+		var v uint64
+		for p.pos < len(p.src) && p.IsDigit(p.src[p.pos]) {
+			v = v*10 + uint64(p.src[p.pos]-'0')
+			p.pos++
+		}
+		return pluralNum{v: v}, nil
+	}
+	return nil, p.error("unexpected token")
+}
+
+// /////////////////////////////////////////////////////////////
+// 言語ごとの既定の Plural-Forms.
+// CLDR の規則から gettext 向けに作られたもの
+// /////////////////////////////////////////////////////////////
+var defaultPluralForms = map[string]string{
+	"ja":    "nplurals=1; plural=0;",
+	"ko":    "nplurals=1; plural=0;",
+	"zh":    "nplurals=1; plural=0;",
+	"vi":    "nplurals=1; plural=0;",
+	"th":    "nplurals=1; plural=0;",
+	"id":    "nplurals=1; plural=0;",
+	"ms":    "nplurals=1; plural=0;",
+	"en":    "nplurals=2; plural=(n != 1);",
+	"de":    "nplurals=2; plural=(n != 1);",
+	"nl":    "nplurals=2; plural=(n != 1);",
+	"sv":    "nplurals=2; plural=(n != 1);",
+	"da":    "nplurals=2; plural=(n != 1);",
+	"nb":    "nplurals=2; plural=(n != 1);",
+	"nn":    "nplurals=2; plural=(n != 1);",
+	"no":    "nplurals=2; plural=(n != 1);",
+	"fi":    "nplurals=2; plural=(n != 1);",
+	"et":    "nplurals=2; plural=(n != 1);",
+	"el":    "nplurals=2; plural=(n != 1);",
+	"hu":    "nplurals=2; plural=(n != 1);",
+	"bg":    "nplurals=2; plural=(n != 1);",
+	"tr":    "nplurals=2; plural=(n != 1);",
+	"it":    "nplurals=2; plural=(n != 1);",
+	"es":    "nplurals=2; plural=(n != 1);",
+	"pt":    "nplurals=2; plural=(n != 1);",
+	"pt_BR": "nplurals=2; plural=(n > 1);",
+	"fr":    "nplurals=2; plural=(n > 1);",
+	"ru":    "nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);",
+	"uk":    "nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);",
+	"be":    "nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);",
+	"sr":    "nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);",
+	"hr":    "nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);",
+	"bs":    "nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);",
+	"pl":    "nplurals=3; plural=(n==1 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);",
+	"cs":    "nplurals=3; plural=(n==1) ? 0 : (n>=2 && n<=4) ? 1 : 2;",
+	"sk":    "nplurals=3; plural=(n==1) ? 0 : (n>=2 && n<=4) ? 1 : 2;",
+	"lt":    "nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && (n%100<10 || n%100>=20) ? 1 : 2);",
+	"lv":    "nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n != 0 ? 1 : 2);",
+	"ro":    "nplurals=3; plural=(n==1 ? 0 : (n==0 || (n%100 > 0 && n%100 < 20)) ? 1 : 2);",
+	"sl":    "nplurals=4; plural=(n%100==1 ? 0 : n%100==2 ? 1 : n%100==3 || n%100==4 ? 2 : 3);",
+	"ga":    "nplurals=5; plural=(n==1 ? 0 : n==2 ? 1 : n>=3 && n<=6 ? 2 : n>=7 && n<=10 ? 3 : 4);",
+	"ar":    "nplurals=6; plural=(n==0 ? 0 : n==1 ? 1 : n==2 ? 2 : n%100>=3 && n%100<=10 ? 3 : n%100>=11 ? 4 : 5);",
+	"he":    "nplurals=2; plural=(n != 1);",
+}
+
+// ja_JP, ja-JP, ja の順に探す
+func DefaultPluralForms(locale string) (string, bool) {
+	locale = strings.ReplaceAll(locale, "-", "_")
+	if v, ok := defaultPluralForms[locale]; ok {
+		return v, true
+	}
+	if n := strings.Index(locale, "_"); n > 0 {
+		locale = locale[:n]
+	}
+	v, ok := defaultPluralForms[strings.ToLower(locale)]
+	return v, ok
+}
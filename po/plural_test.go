@@ -0,0 +1,80 @@
+package po
+
+import (
+	"testing"
+)
+
+func TestPluralExpr(t *testing.T) {
+	for _, s := range []struct {
+		input  string
+		expect []uint64 // n = 0, 1, 2, ...
+	}{
+		{"0", []uint64{0, 0, 0}},
+		{"n != 1", []uint64{1, 0, 1, 1}},
+		{"(n > 1)", []uint64{0, 0, 1, 1}},
+		{"n==1 ? 0 : n==2 ? 1 : 2", []uint64{2, 0, 1, 2}},
+		{"!(n%2)", []uint64{1, 0, 1, 0}},
+		{"n/2 + 1*3 - 1", []uint64{2, 2, 3, 3, 4}},
+		{"n>=2 && n<=3 || n==0", []uint64{1, 0, 1, 1, 0}},
+		{"n%0 + n/0", []uint64{0, 0}},
+		{"(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2)",
+			[]uint64{2, 0, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 0, 1}},
+	} {
+		expr, err := ParsePluralExpr(s.input)
+		if err != nil {
+			t.Errorf("input=%s: unexpected error: %v", s.input, err)
+			continue
+		}
+		for n, v := range s.expect {
+			if actual := expr.Eval(uint64(n)); actual != v {
+				t.Errorf("input=%s, n=%d: expect=%d, actual=%d", s.input, n, v, actual)
+			}
+		}
+	}
+
+	for _, input := range []string{"", "n +", "(n", "m", "n ? 1", "1 2", "n = 1", "nn"} {
+		if _, err := ParsePluralExpr(input); err == nil {
+			t.Errorf("input=%s: expect error", input)
+		}
+	}
+}
+
+func TestDefaultPluralForms(t *testing.T) {
+	for locale := range defaultPluralForms {
+		v, ok := DefaultPluralForms(locale)
+		if !ok {
+			t.Errorf("%s: not found", locale)
+			continue
+		}
+		nplurals, plural, ok := ParsePluralForms(v)
+		if !ok {
+			t.Errorf("%s: invalid Plural-Forms: %s", locale, v)
+			continue
+		}
+		expr, _ := ParsePluralExpr(plural)
+		hist := expr.Histogram(200)
+		for i := 0; i < nplurals; i++ {
+			if hist[uint64(i)] == 0 {
+				t.Errorf("%s: msgstr[%d] is never selected: %s", locale, i, v)
+			}
+		}
+		if len(hist) != nplurals {
+			t.Errorf("%s: %v", locale, hist)
+		}
+	}
+
+	for _, s := range []struct {
+		locale string
+		expect string
+	}{
+		{"ja_JP", "ja"},
+		{"pt-BR", "pt_BR"},
+		{"pt_PT", "pt"},
+		{"EN", "en"},
+	} {
+		v, ok := DefaultPluralForms(s.locale)
+		if !ok || v != defaultPluralForms[s.expect] {
+			t.Errorf("%s: expect=%s, actual=%s", s.locale, defaultPluralForms[s.expect], v)
+		}
+	}
+}
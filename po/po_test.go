@@ -0,0 +1,410 @@
+package po
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParsePoContext(t *testing.T) {
+	input := `
+msgid "Open"
+msgstr "開く"
+
+msgctxt "status"
+msgid "Open"
+msgstr "公開中"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := f.Entries
+
+	type Expect struct {
+		Context string
+		MsgID   string
+		MsgStr  string
+		Key     string
+	}
+
+	expect := []Expect{
+		{"", "Open", "開く", "Open"},
+		{"status", "Open", "公開中", "status\x04Open"},
+	}
+	if len(entries) != len(expect) {
+		t.Fatalf("\nexpect=%v\nactual=%v\n", expect, entries)
+	}
+	for i, v := range expect {
+		e := entries[i]
+		if e.Context != v.Context || e.MsgID != v.MsgID || e.MsgStr != v.MsgStr || e.Key() != v.Key {
+			t.Errorf("\n%d: expect=%v\nactual=%v\n", i, v, *e)
+		}
+	}
+}
+
+func TestParsePoPlural(t *testing.T) {
+	input := `
+msgid "{0} file"
+msgid_plural "{0} files"
+msgstr[0] "{0} file"
+msgstr[1] "{0} "
+"files"
+
+msgid "single"
+msgstr "single"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := f.Entries
+	if len(entries) != 2 {
+		t.Fatalf("expect 2 entries, actual=%v", entries)
+	}
+
+	e := entries[0]
+	if !e.IsPlural() || e.MsgID != "{0} file" || e.MsgIDPlural != "{0} files" {
+		t.Errorf("unexpected plural entry: %v", *e)
+	}
+	if len(e.MsgStrs) != 2 || e.MsgStrs[0] != "{0} file" || e.MsgStrs[1] != "{0} files" || e.MsgStr != e.MsgStrs[0] {
+		t.Errorf("unexpected msgstr[N]: %v", e.MsgStrs)
+	}
+	if e.MsgIDForm(0) != e.MsgID || e.MsgIDForm(1) != e.MsgIDPlural {
+		t.Errorf("unexpected MsgIDForm: %v", *e)
+	}
+
+	e = entries[1]
+	if e.IsPlural() || len(e.MsgStrForms()) != 1 || e.MsgStrForms()[0] != "single" {
+		t.Errorf("unexpected singular entry: %v", *e)
+	}
+
+	for _, input := range []string{
+		"msgid \"a\"\nmsgid_plural \"b\"\nmsgstr[1] \"c\"\n",
+		"msgid \"a\"\nmsgid_plural \"b\"\nmsgstr[0] \"c\"\nmsgstr[2] \"d\"\n",
+		"msgid \"a\"\nmsgid_plural \"b\"\nmsgstr \"c\"\n",
+	} {
+		if _, err := ParsePo(strings.NewReader(input)); err == nil {
+			t.Errorf("expect error: %s", input)
+		}
+	}
+}
+
+func TestParsePoComments(t *testing.T) {
+	input := `# translator comment
+#
+#. extracted comment
+#: src/Controller/FooController.php:12 src/View/foo.ctp:3
+#, fuzzy, php-format
+#| msgctxt "old"
+#| msgid "Old "
+#| "message"
+msgid "New message"
+msgstr "新しいメッセージ"
+
+#~ msgid "obsolete"
+#~ msgstr "廃止"
+
+# trailing comment`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := f.Entries
+	if len(entries) != 2 {
+		t.Fatalf("expect 2 entries, actual=%v", entries)
+	}
+
+	e := entries[0]
+	for _, s := range []struct {
+		name   string
+		expect []string
+		actual []string
+	}{
+		{"Comments", []string{"translator comment", ""}, e.Comments},
+		{"ExtractedComments", []string{"extracted comment"}, e.ExtractedComments},
+		{"References", []string{"src/Controller/FooController.php:12", "src/View/foo.ctp:3"}, e.References},
+		{"Flags", []string{"fuzzy", "php-format"}, e.Flags},
+	} {
+		if strings.Join(s.expect, "|") != strings.Join(s.actual, "|") || len(s.expect) != len(s.actual) {
+			t.Errorf("%s\nexpect=%q\nactual=%q\n", s.name, s.expect, s.actual)
+		}
+	}
+
+	if e.PrevContext != "old" || e.PrevMsgID != "Old message" || e.PrevMsgIDPlural != "" {
+		t.Errorf("unexpected previous: %q %q %q", e.PrevContext, e.PrevMsgID, e.PrevMsgIDPlural)
+	}
+	if !e.IsFuzzy() || !e.HasFlag("php-format") || e.HasFlag("c-format") {
+		t.Errorf("unexpected flags: %v", e.Flags)
+	}
+}
+
+func TestParsePoObsolete(t *testing.T) {
+	input := `msgid "active"
+msgstr "有効"
+
+#~ msgctxt "ctx"
+#~ msgid "obsolete"
+#~ msgstr ""
+#~ "廃止"
+
+#, fuzzy
+#~| msgid "old"
+#~ msgid "obsolete2"
+#~ msgid_plural "obsoletes2"
+#~ msgstr[0] "廃止2"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := f.Entries
+	if len(entries) != 3 {
+		t.Fatalf("expect 3 entries, actual=%v", entries)
+	}
+
+	for i, s := range []struct {
+		obsolete bool
+		context  string
+		msgid    string
+		msgstr   string
+	}{
+		{false, "", "active", "有効"},
+		{true, "ctx", "obsolete", "廃止"},
+		{true, "", "obsolete2", "廃止2"},
+	} {
+		e := entries[i]
+		if e.Obsolete != s.obsolete || e.Context != s.context || e.MsgID != s.msgid || e.MsgStr != s.msgstr {
+			t.Errorf("%d: expect=%v\nactual=%v\n", i, s, *e)
+		}
+	}
+
+	if e := entries[2]; !e.IsFuzzy() || e.PrevMsgID != "old" || e.MsgIDPlural != "obsoletes2" {
+		t.Errorf("unexpected obsolete entry: %v", *e)
+	}
+}
+
+func TestParsePoHeader(t *testing.T) {
+	input := `# header comment
+msgid ""
+msgstr ""
+"Project-Id-Version: foo\n"
+"PO-Revision-Date: 2025-01-01 00:00+0900\n"
+"Language: ja_JP\n"
+"Content-Type: text/plain; charset=UTF-8\n"
+"Plural-Forms: nplurals=1; plural=0;\n"
+
+msgid "Hello"
+msgstr "こんにちは"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Header == nil {
+		t.Fatalf("missing header")
+	}
+	if len(f.Entries) != 1 || f.Entries[0].MsgID != "Hello" {
+		t.Errorf("unexpected entries: %v", f.Entries)
+	}
+
+	h := f.Header
+	if len(h.Fields) != 5 || h.Fields[0].Key != "Project-Id-Version" || h.Fields[0].Value != "foo" {
+		t.Errorf("unexpected fields: %v", h.Fields)
+	}
+	if h.Charset() != "UTF-8" || h.Language() != "ja_JP" {
+		t.Errorf("unexpected charset=%s, language=%s", h.Charset(), h.Language())
+	}
+	if v, ok := h.Get("po-revision-date"); !ok || v != "2025-01-01 00:00+0900" {
+		t.Errorf("unexpected PO-Revision-Date: %s", v)
+	}
+	if len(h.Entry.Comments) != 1 {
+		t.Errorf("unexpected header comments: %v", h.Entry.Comments)
+	}
+}
+
+func TestParsePluralForms(t *testing.T) {
+	for _, s := range []struct {
+		input    string
+		ok       bool
+		nplurals int
+		plural   string
+	}{
+		{"nplurals=1; plural=0;", true, 1, "0"},
+		{"nplurals=2; plural=(n != 1);", true, 2, "(n != 1)"},
+		{" nplurals = 2 ; plural = n>1 ", true, 2, "n>1"},
+		{"nplurals=0; plural=0;", false, 0, ""},
+		{"nplurals=2;", false, 0, ""},
+		{"plural=0; nplurals=1;", false, 0, ""},
+	} {
+		nplurals, plural, ok := ParsePluralForms(s.input)
+		if ok != s.ok || nplurals != s.nplurals || plural != s.plural {
+			t.Errorf("\ninput =%s\nexpect=%v %d %s\nactual=%v %d %s", s.input, s.ok, s.nplurals, s.plural, ok, nplurals, plural)
+		}
+	}
+}
+
+func TestParsePoConcurrent(t *testing.T) {
+	input := `msgid "a"
+msgstr "A"
+
+msgid "b"
+msgstr "B"
+`
+	var wg sync.WaitGroup
+	errs := make(chan string, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := &Parser{}
+			if i%4 == 0 {
+				// デバッグ出力しない範囲で設定を変える
+				p.ErrorVerbose = true
+			}
+			for j := 0; j < 50; j++ {
+				f, err := p.Parse(strings.NewReader(input))
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				if len(f.Entries) != 2 || f.Entries[0].MsgID != "a" || f.Entries[1].MsgID != "b" {
+					errs <- fmt.Sprintf("unexpected entries: %v", f.Entries)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestParsePoEscape(t *testing.T) {
+	for _, s := range []struct {
+		input  string
+		expect string
+		diags  int
+	}{
+		{`"It's \"ok\""`, `It's "ok"`, 0},
+		{`"a\nb\tc\\d"`, "a\nb\tc\\d", 0},
+		{`"\a\b\f\r\v\?\'"`, "\a\b\f\r\v?'", 0},
+		{`"\101\x42\303\251"`, "ABé", 0},
+		{`"\q"`, `\q`, 1},
+		{`"\x"`, `\x`, 1},
+		{`"\x123"`, "#", 1},
+		{`"abc`, "abc", 1},
+	} {
+		f, err := ParsePo(strings.NewReader("msgid \"a\"\nmsgstr " + s.input + "\n"))
+		if err != nil {
+			t.Errorf("input=%s: unexpected error: %v", s.input, err)
+			continue
+		}
+		if len(f.Entries) != 1 || f.Entries[0].MsgStr != s.expect || len(f.Diagnostics) != s.diags {
+			t.Errorf("\ninput =%s\nexpect=%q, %d\nactual=%v, %v", s.input, s.expect, s.diags, f.Entries, f.Diagnostics)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []struct {
+		input  string
+		expect string
+	}{
+		{`abc`, `"abc"`},
+		{`It's "ok"`, `"It's \"ok\""`},
+		{"a\nb\tc\\d", `"a\nb\tc\\d"`},
+		{"\a\b\f\r\v\x01\x7f", `"\a\b\f\r\v\001\177"`},
+		{"日本語", `"日本語"`},
+	} {
+		actual := Quote(s.input)
+		if actual != s.expect {
+			t.Errorf("\ninput =%q\nexpect=%s\nactual=%s", s.input, s.expect, actual)
+			continue
+		}
+
+		// 読み戻すと元に戻る
+		f, err := ParsePo(strings.NewReader("msgid " + actual + "\nmsgstr \"\"\n"))
+		if err != nil || len(f.Entries) != 1 || f.Entries[0].MsgID != s.input {
+			t.Errorf("\ninput =%q\nactual=%v, %v", s.input, f, err)
+		}
+	}
+}
+
+func TestParsePoRecovery(t *testing.T) {
+	input := `msgid "a"
+msgstr "A"
+
+msgid "b"
+msgstr "B" garbage
+
+msgid "c"
+msgstr "C"
+
+msgid "d"
+
+msgid "e"
+msgstr "E"
+
+msgid "f"
+msgid_plural "fs"
+msgstr[1] "F"
+
+msgid "g"
+msgstr "G"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err == nil {
+		t.Fatalf("expect error")
+	}
+
+	msgids := make([]string, 0)
+	for _, e := range f.Entries {
+		msgids = append(msgids, e.MsgID)
+	}
+	if strings.Join(msgids, ",") != "a,b,c,e,f,g" {
+		t.Errorf("unexpected entries: %v", msgids)
+	}
+
+	lines := make([]int, 0)
+	for _, d := range f.Diagnostics {
+		lines = append(lines, d.Pos.Line)
+	}
+	if fmt.Sprint(lines) != "[5 12 17]" {
+		t.Errorf("unexpected diagnostics: %v", f.Diagnostics)
+	}
+}
+
+// 壊れたエントリが続いても, それぞれ報告して後の有効なエントリを読む
+func TestParsePoRecoveryConsecutive(t *testing.T) {
+	for _, s := range []struct {
+		input  string
+		msgids string
+		lines  string
+	}{
+		{"msgid \"a\" x\nmsgid \"b\" y\nmsgid \"c\" z\nmsgid \"d\"\nmsgstr \"D\"\n", "d", "[1 2 3]"},
+		{"msgid \"a\" x\nmsgid \"b\" y\nmsgid \"c\"\nmsgstr \"C\"\nmsgid \"d\" z\nmsgid \"e\"\nmsgstr \"E\"\n", "c,e", "[1 2 5]"},
+		{"msgid \"a\"\nmsgstr \"A\" x\nmsgid \"b\" y\nmsgid \"c\"\nmsgstr \"C\"\n", "a,c", "[2 3]"},
+	} {
+		f, err := ParsePo(strings.NewReader(s.input))
+		if err == nil {
+			t.Errorf("input=%q: expect error", s.input)
+			continue
+		}
+
+		msgids := make([]string, 0)
+		for _, e := range f.Entries {
+			msgids = append(msgids, e.MsgID)
+		}
+		lines := make([]int, 0)
+		for _, d := range f.Diagnostics {
+			lines = append(lines, d.Pos.Line)
+		}
+		if strings.Join(msgids, ",") != s.msgids || fmt.Sprint(lines) != s.lines {
+			t.Errorf("\ninput =%q\nexpect=%s %s\nactual=%v %v", s.input, s.msgids, s.lines, msgids, f.Diagnostics)
+		}
+	}
+}
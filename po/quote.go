@@ -0,0 +1,47 @@
+package po
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 文字列を PO ファイルの "..." 形式にする. scanString の逆
+func Quote(s string) string {
+	return `"` + Escape(s) + `"`
+}
+
+// エスケープシーケンスに変換する. 前後の " は付けない
+func Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\v':
+			sb.WriteString(`\v`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				// その他の制御文字は 8 進数で
+				sb.WriteString(fmt.Sprintf(`\%03o`, c))
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	return sb.String()
+}
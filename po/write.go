@@ -0,0 +1,251 @@
+package po
+
+import (
+	"bufio"
+	"io"
+	"polinco/com"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// /////////////////////////////////////////////////////////////
+// 読み込んだときの表記
+// /////////////////////////////////////////////////////////////
+type rawEntry struct {
+	lead     string      // 前のエントリとの間の空行など
+	text     string      // コメントから最後の文字列まで
+	snapshot com.PoEntry // 読み込んだときの値. 変更されたかの判定用
+}
+
+func newRawEntry(e *com.PoEntry, lead, text string) *rawEntry {
+	return &rawEntry{lead: lead, text: text, snapshot: copyEntry(e)}
+}
+
+func copyEntry(e *com.PoEntry) com.PoEntry {
+	c := *e
+	c.MsgStrs = copyStrings(e.MsgStrs)
+	c.Comments = copyStrings(e.Comments)
+	c.ExtractedComments = copyStrings(e.ExtractedComments)
+	c.References = copyStrings(e.References)
+	c.Flags = copyStrings(e.Flags)
+	return c
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+// 書き出す内容が同じか. 位置や参照回数は比較しない
+func sameEntry(a, b *com.PoEntry) bool {
+	return a.Context == b.Context &&
+		a.MsgID == b.MsgID &&
+		a.MsgStr == b.MsgStr &&
+		a.MsgIDPlural == b.MsgIDPlural &&
+		a.IsPlural() == b.IsPlural() &&
+		sameStrings(a.MsgStrs, b.MsgStrs) &&
+		sameStrings(a.Comments, b.Comments) &&
+		sameStrings(a.ExtractedComments, b.ExtractedComments) &&
+		sameStrings(a.References, b.References) &&
+		sameStrings(a.Flags, b.Flags) &&
+		a.PrevContext == b.PrevContext &&
+		a.PrevMsgID == b.PrevMsgID &&
+		a.PrevMsgIDPlural == b.PrevMsgIDPlural &&
+		a.Obsolete == b.Obsolete
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// /////////////////////////////////////////////////////////////
+// 書き出し
+// /////////////////////////////////////////////////////////////
+
+// f を PO ファイルとして書き出す.
+// 読み込んだまま変更していないエントリは元の表記のまま書き出すので,
+// ParsePo した結果をそのまま Write すると同じバイト列になる.
+func Write(w io.Writer, f *File) error {
+	bw := bufio.NewWriter(w)
+
+	entries := f.Entries
+	if f.Header != nil {
+		// ヘッダは読み込んだときと同じ位置に
+		n := f.headerIndex
+		if n > len(entries) {
+			n = len(entries)
+		}
+		entries = make([]*com.PoEntry, 0, len(f.Entries)+1)
+		entries = append(entries, f.Entries[:n]...)
+		entries = append(entries, f.Header.Entry)
+		entries = append(entries, f.Entries[n:]...)
+	}
+
+	for i, e := range entries {
+		raw, ok := f.raw[e]
+		if ok {
+			bw.WriteString(raw.lead)
+		} else if i > 0 {
+			bw.WriteString("\n\n")
+		}
+
+		if ok && sameEntry(e, &raw.snapshot) {
+			bw.WriteString(raw.text)
+		} else {
+			writeEntry(bw, e, 0)
+		}
+	}
+
+	if f.raw != nil {
+		bw.WriteString(f.trailer)
+	} else if len(entries) > 0 {
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// 最後の改行は書かない.
+// width > 0 なら 1 行が width 文字以内になるように文字列を折り返す
+func writeEntry(w *bufio.Writer, e *com.PoEntry, width int) {
+	lines := make([]string, 0)
+	for _, c := range e.Comments {
+		if c == "" {
+			lines = append(lines, "#")
+		} else {
+			lines = append(lines, "# "+c)
+		}
+	}
+	for _, c := range e.ExtractedComments {
+		lines = append(lines, "#. "+c)
+	}
+	if len(e.References) > 0 {
+		lines = append(lines, "#: "+strings.Join(e.References, " "))
+	}
+	if len(e.Flags) > 0 {
+		lines = append(lines, "#, "+strings.Join(e.Flags, ", "))
+	}
+
+	prefix, prevPrefix := "", "#| "
+	if e.Obsolete {
+		prefix, prevPrefix = "#~ ", "#~| "
+	}
+	bodyWidth, prevWidth := width, width
+	if width > 0 {
+		bodyWidth -= len(prefix)
+		prevWidth -= len(prevPrefix)
+	}
+
+	// #| 以前の msgid
+	prev := make([]string, 0)
+	if e.PrevContext != "" {
+		prev = appendKeyword(prev, "msgctxt", e.PrevContext, prevWidth)
+	}
+	if e.PrevMsgID != "" {
+		prev = appendKeyword(prev, "msgid", e.PrevMsgID, prevWidth)
+	}
+	if e.PrevMsgIDPlural != "" {
+		prev = appendKeyword(prev, "msgid_plural", e.PrevMsgIDPlural, prevWidth)
+	}
+	for _, line := range prev {
+		lines = append(lines, prevPrefix+line)
+	}
+
+	body := make([]string, 0)
+	if e.Context != "" {
+		body = appendKeyword(body, "msgctxt", e.Context, bodyWidth)
+	}
+	body = appendKeyword(body, "msgid", e.MsgID, bodyWidth)
+	if e.IsPlural() {
+		body = appendKeyword(body, "msgid_plural", e.MsgIDPlural, bodyWidth)
+		for i, s := range e.MsgStrs {
+			body = appendKeyword(body, "msgstr["+strconv.Itoa(i)+"]", s, bodyWidth)
+		}
+	} else {
+		body = appendKeyword(body, "msgstr", e.MsgStr, bodyWidth)
+	}
+	for _, line := range body {
+		lines = append(lines, prefix+line)
+	}
+
+	w.WriteString(strings.Join(lines, "\n"))
+}
+
+// keyword "..." の行を追加する.
+// 改行を含む場合や 1 行に収まらない場合は keyword "" に続けて分割する
+func appendKeyword(lines []string, keyword, s string, width int) []string {
+	parts := splitLines(s)
+	if len(parts) == 1 && (width <= 0 || quotedWidth(keyword+" ", s) <= width) {
+		return append(lines, keyword+" "+Quote(s))
+	}
+	lines = append(lines, keyword+` ""`)
+	for _, p := range parts {
+		for _, q := range wrapString(p, width) {
+			lines = append(lines, Quote(q))
+		}
+	}
+	return lines
+}
+
+// prefix "s" と書いたときの文字数
+func quotedWidth(prefix, s string) int {
+	return utf8.RuneCountInString(prefix + Quote(s))
+}
+
+// 空白の直後で折り返す. 空白が無い場合ははみ出してもよい
+func wrapString(s string, width int) []string {
+	if width <= 0 || quotedWidth("", s) <= width {
+		return []string{s}
+	}
+
+	ret := make([]string, 0)
+	cur := ""
+	for _, word := range splitWords(s) {
+		if cur != "" && quotedWidth("", cur+word) > width {
+			ret = append(ret, cur)
+			cur = ""
+		}
+		cur += word
+	}
+	return append(ret, cur)
+}
+
+// 後ろの空白を含めて単語に分ける
+func splitWords(s string) []string {
+	ret := make([]string, 0)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' && (i+1 == len(s) || s[i+1] != ' ') {
+			ret = append(ret, s[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		ret = append(ret, s[start:])
+	}
+	return ret
+}
+
+// 改行の直後で分割する
+func splitLines(s string) []string {
+	ret := make([]string, 0)
+	for {
+		n := strings.Index(s, "\n")
+		if n < 0 || n == len(s)-1 {
+			break
+		}
+		ret = append(ret, s[:n+1])
+		s = s[n+1:]
+	}
+	return append(ret, s)
+}
@@ -0,0 +1,308 @@
+package po
+
+import (
+	"bytes"
+	"polinco/com"
+	"strings"
+	"testing"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"\n\n",
+		"msgid \"a\"\nmsgstr \"b\"",
+		`# SOME DESCRIPTIVE TITLE.
+#, fuzzy
+msgid ""
+msgstr ""
+"Project-Id-Version: foo\n"
+"Content-Type: text/plain; charset=UTF-8\n"
+
+#. extracted
+#: src/a.php:1   src/b.php:2
+#,php-format
+msgctxt   "ctx"
+msgid "multi "
+      "line"
+msgstr ""
+"複数\n"
+"行"
+
+
+msgid "{0} file"
+msgid_plural "{0} files"
+msgstr[0] "{0} ファイル"
+
+#~ msgid "obsolete"
+#~ msgstr ""
+#~ "廃止"
+
+#~| msgid "old"
+#~ msgid "obsolete2"
+#~ msgstr "廃止2"
+# trailing comment
+`,
+		"msgid \"a\"\r\nmsgstr \"\\x41\\101\"\r\n",
+		"msgid \"a\"\nmsgstr \"A\"\nmsgid \"b\"\nmsgstr \"B\"\n\nmsgid \"\"\nmsgstr \"Language: ja\\n\"\n",
+	} {
+		f, err := ParsePo(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("input=%q: unexpected error: %v", input, err)
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := Write(&buf, f); err != nil {
+			t.Errorf("input=%q: unexpected error: %v", input, err)
+			continue
+		}
+		if buf.String() != input {
+			t.Errorf("\ninput =%q\nactual=%q", input, buf.String())
+		}
+	}
+}
+
+func TestWriteModified(t *testing.T) {
+	input := `msgid ""
+msgstr ""
+"Language: ja\n"
+
+# keep
+msgid "a"
+msgstr "A"
+
+#: src/b.php:1
+msgid "b"
+msgstr "B"
+
+#~ msgid "c"
+#~ msgstr "C"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f.Header.Set("PO-Revision-Date", "2025-01-01 00:00+0900")
+	f.Entries[1].MsgStr = "Bee\nbee"
+	f.Entries[1].Flags = append(f.Entries[1].Flags, "fuzzy")
+	f.Entries[1].PrevMsgID = "bb"
+	f.Entries[2].MsgStr = "Sea"
+	f.Entries = append(f.Entries, &com.PoEntry{
+		MsgID: "{0} file", MsgIDPlural: "{0} files",
+		MsgStr: "{0} ファイル", MsgStrs: []string{"{0} ファイル"},
+	})
+
+	expect := `msgid ""
+msgstr ""
+"Language: ja\n"
+"PO-Revision-Date: 2025-01-01 00:00+0900\n"
+
+# keep
+msgid "a"
+msgstr "A"
+
+#: src/b.php:1
+#, fuzzy
+#| msgid "bb"
+msgid "b"
+msgstr ""
+"Bee\n"
+"bee"
+
+#~ msgid "c"
+#~ msgstr "Sea"
+
+msgid "{0} file"
+msgid_plural "{0} files"
+msgstr[0] "{0} ファイル"
+`
+	var buf bytes.Buffer
+	if err := Write(&buf, f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != expect {
+		t.Errorf("\nexpect=%s\nactual=%s", expect, buf.String())
+	}
+}
+
+func TestWriteNew(t *testing.T) {
+	f := &File{Entries: []*com.PoEntry{
+		{MsgID: "a", MsgStr: "A", Comments: []string{"comment", ""}},
+		{Context: "ctx", MsgID: "b\"", MsgStr: "", Obsolete: true, PrevMsgID: "old"},
+	}}
+
+	expect := `# comment
+#
+msgid "a"
+msgstr "A"
+
+#~| msgid "old"
+#~ msgctxt "ctx"
+#~ msgid "b\""
+#~ msgstr ""
+`
+	var buf bytes.Buffer
+	if err := Write(&buf, f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != expect {
+		t.Errorf("\nexpect=%s\nactual=%s", expect, buf.String())
+	}
+}
+
+func TestFormat(t *testing.T) {
+	input := `#, fuzzy
+msgid ""
+msgstr "Language: ja\n"
+"X-Custom: 1\n"
+"Project-Id-Version: x\n"
+
+#~ msgid "old"
+#~ msgstr "古い"
+
+#: src/b.php:20
+msgid   "b"
+msgstr "\x42"
+
+#: src/b.php:3
+msgctxt "c"
+msgid "a long message to be wrapped at spaces"
+msgstr "a\nb"
+
+msgid "a"
+msgstr "A"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, s := range []struct {
+		option FormatOption
+		expect string
+	}{
+		{FormatOption{Width: 30, Sort: SortReference}, `#, fuzzy
+msgid ""
+msgstr ""
+"Project-Id-Version: x\n"
+"Language: ja\n"
+"X-Custom: 1\n"
+
+msgid "a"
+msgstr "A"
+
+#: src/b.php:3
+msgctxt "c"
+msgid ""
+"a long message to be "
+"wrapped at spaces"
+msgstr ""
+"a\n"
+"b"
+
+#: src/b.php:20
+msgid "b"
+msgstr "B"
+
+#~ msgid "old"
+#~ msgstr "古い"
+`},
+		{FormatOption{Sort: SortMsgID}, `#, fuzzy
+msgid ""
+msgstr ""
+"Project-Id-Version: x\n"
+"Language: ja\n"
+"X-Custom: 1\n"
+
+msgid "a"
+msgstr "A"
+
+#: src/b.php:3
+msgctxt "c"
+msgid "a long message to be wrapped at spaces"
+msgstr ""
+"a\n"
+"b"
+
+#: src/b.php:20
+msgid "b"
+msgstr "B"
+
+#~ msgid "old"
+#~ msgstr "古い"
+`},
+	} {
+		var buf bytes.Buffer
+		if err := Format(&buf, f, s.option); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buf.String() != s.expect {
+			t.Errorf("option=%v\nexpect=%s\nactual=%s", s.option, s.expect, buf.String())
+		}
+
+		// 2 回目は変わらない
+		f2, _ := ParsePo(strings.NewReader(buf.String()))
+		var buf2 bytes.Buffer
+		Format(&buf2, f2, s.option)
+		if buf2.String() != buf.String() {
+			t.Errorf("option=%v: not idempotent\n%s", s.option, buf2.String())
+		}
+	}
+
+	// 元のファイルは変更しない
+	var buf bytes.Buffer
+	Write(&buf, f)
+	if buf.String() != input {
+		t.Errorf("input was modified:\n%s", buf.String())
+	}
+}
+
+// 最後のエントリより後ろのコメントと, key: value の形でないヘッダの行は残す
+func TestFormatTrailer(t *testing.T) {
+	input := `msgid ""
+msgstr ""
+"Language: ja\n"
+"Generated by hand\n"
+"Project-Id-Version: x\n"
+
+msgid "a"
+msgstr "A"
+
+# msgid "removed"
+# msgstr "消した"
+`
+	f, err := ParsePo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expect := `msgid ""
+msgstr ""
+"Project-Id-Version: x\n"
+"Language: ja\n"
+"Generated by hand\n"
+
+msgid "a"
+msgstr "A"
+
+# msgid "removed"
+# msgstr "消した"
+`
+	var buf bytes.Buffer
+	if err := Format(&buf, f, FormatOption{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != expect {
+		t.Errorf("\nexpect=%s\nactual=%s", expect, buf.String())
+	}
+
+	// 2 回目も変わらない
+	f, _ = ParsePo(strings.NewReader(buf.String()))
+	var again bytes.Buffer
+	Format(&again, f, FormatOption{})
+	if again.String() != expect {
+		t.Errorf("not idempotent:\n%s", again.String())
+	}
+}